@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"io"
 	"log"
 	"math/rand"
 	"os"
@@ -15,8 +15,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/muesli/gamut"
 )
 
 type Pixel int
@@ -29,9 +27,13 @@ const (
 	Fill
 	Outline
 	Delimiter
+	Off           //inactive bits, when they aren't drawn as another pixel
 	PixelsDefined //Add any new tracked pixels above this.
 )
 
+//Names we accept for our pixels, when they're given colors in palette roles and manifests.
+var pixelNames = map[string]Pixel{"bit": Bit, "color": Bit, "accent": Accent, "fill": Fill, "outline": Outline, "outcolor": Outline, "background": Background, "off": Off}
+
 var Black = color.RGBA{0, 0, 0, 255}
 var Red = color.RGBA{255, 0, 0, 255}
 var Green = color.RGBA{0, 255, 0, 255}
@@ -43,27 +45,80 @@ var LGray = color.RGBA{85, 85, 85, 255}
 var HGray = color.RGBA{170, 170, 170, 255}
 
 //Flags.  Trying to be a bit more terse than the readme.  out- names are probably too abundant, and legacy is not ideal.
-var templateString = flag.String("template", "", "Choose template to render, template must be in Templates folder.")
+var templateString = flag.String("template", "", "Choose template to render, by name from a Templates folder or BITSPRITE_PATH, or as a path to a .png or .json file.")
 var foldPref = flag.String("fold", "", "Sets fold preference for template if desired, use even and odd, all other values default to no fold. (e, even=Even; o, odd=Odd)")
 var vertFoldPref = flag.String("vertfold", "", "Sets fold preference accross bottom of image, use even and odd, all other values default to no fold. (e, even=Even; o, odd=Odd)")
 var colorPref = flag.String("color", "", "Sets color of activated bit pixels, use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
 var accentPref = flag.String("accent", "", "Sets the color of the accent pixels, use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
 var fillPref = flag.String("fill", "", "Sets the color of the fill pixels,  use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
 var backgroundPref = flag.String("background", "", "Sets color of background,  use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
-var outlineColorPref = flag.String("outcolor", "#000000", "Sets the color of the outline pixels,  use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
+var outlineColorPref = flag.String("outcolor", "", "Sets the color of the outline pixels (black if unset),  use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).")
+var palettePref = flag.String("palette", "", "Takes colors from a palette file, use a .gpl, .hex, .txt (Paint.NET), .pal (JASC) or .ase file.")
+var paletteRolesPref = flag.String("paletteroles", "auto", "Assigns palette entries to pixels, use role=index, counting from 0 (bit=3,accent=5:6,fill=1,outline=0,background=7).  Unlisted roles are picked automatically.")
+var paletteSnapPref = flag.Bool("palettesnap", false, "Snaps every color to the closest color in the palette, so blends stay on palette, use Golang Bool values.")
+var blendSpacePref = flag.String("blendspace", "lab", "Sets the color space blends are mixed in. (rgb; hsl; lab; oklab)")
+var easingPref = flag.String("easing", "linear", "Sets how blends move between their colors. (linear; in; out; inout)")
+var randomColorsPref = flag.String("randomcolors", "", "Gives each variant its own random bit, accent and fill colors, picked from the -palette (palette) or from the -hue, -saturation and -lightness ranges (hsl).")
+var harmonyPref = flag.String("harmony", "", "Works out accent, fill, outline and background colors from the bit color, unless they're passed in. (complementary; triadic; analogous; monochrome)")
+var huePref = flag.String("hue", "0:360", "Sets the range of hues random colors are picked from, in degrees.")
+var saturationPref = flag.String("saturation", "0.4:0.9", "Sets the range of saturations random colors are picked from, between 0 and 1.")
+var lightnessPref = flag.String("lightness", "0.35:0.65", "Sets the range of lightness random colors are picked from, between 0 and 1.")
+var colorSeedPref = flag.Int64("colorseed", 0, "Seeds random colors on their own, so a color scheme can be kept while bits change.  0 follows -randseed.")
+var offBitsPref = flag.String("offbits", "outline", "Sets how inactive bits are drawn. (outline; background; fill; off, which uses -offcolor)")
+var offColorPref = flag.String("offcolor", "", "Sets the color of inactive bits with -offbits=off, use Hex or Hex:Hex (#FFFFFF or #000000:#FFFFFF).  Defaults to the outline color.")
+var shadingPref = flag.String("shading", "", "Shades each sprite as though lit from the direction provided, leave empty for flat colors. (topleft; top; topright; left; right; bottomleft; bottom; bottomright)")
+var shadeStrengthPref = flag.Float64("shadestrength", .25, "Sets how much shading lightens and darkens, between 0 and 1.")
 var outlinePref = flag.Bool("outline", true, "Sets outline preference, use Golang Bool values.")
+var outlineNeighborsPref = flag.Int("outlineneighbors", 4, "Outlines the sides of the body with 4, or the corners too with 8.")
+var outlineThicknessPref = flag.Int("outlinethickness", 1, "Sets how many pixels thick outlines are, use a positive integer.")
+var innerOutlinePref = flag.Bool("inneroutline", false, "Outlines fill pixels that touch bits, separating the two, use Golang Bool values.")
+var seloutPref = flag.Bool("selout", false, "Colors each outline pixel with a darkened copy of the color it outlines, use Golang Bool values.")
+var scalerPref = flag.String("scaler", "nearest", "Scales sprites up with a pixel art scaler before -upscale. (nearest; scale2x; scale3x; scale4x; eagle; hq2x; hq3x; xbr)")
 var upscalePref = flag.Int("upscale", 1, "Increases the scale of the template's copies, use a positive integer.")
-var compositePref = flag.Int("sheetwidth", 16, "Sets width of output sprite sheet, use a factor of 256.")
+var compositePref = flag.Int("sheetwidth", 16, "Sets the number of columns in the output sprite sheet, use a positive integer.")
+var sheetHeightPref = flag.Int("sheetheight", 0, "Sets the number of rows in the output sprite sheet instead, working out the columns to fit, 0 to use -sheetwidth.")
+var aspectPref = flag.String("aspect", "", "Works out the columns to make the sprite sheet as close to square as we can, use square.")
 var legacyColors = flag.Bool("legacy", false, "Colors are based on a composite linear gradient of the YCbCr at .5 lumia if true, use Golang Bool values.")
 var outputNamePref = flag.String("outname", "", "Sets the output files to be placed in a generation directory named after the string provided.")
+var outDirPref = flag.String("outdir", "", "Sets the folder output files are placed in, instead of a folder in GenerationDirectory.")
+var namePatternPref = flag.String("namepattern", "{index}", "Sets how individual files are named, from {template}, {index} (or zero padded, like {index:03}) and {bits}.")
 var individualsPref = flag.Bool("individuals", false, "Creates a directory of individual .png files for each image on the spritesheet")
 var randSeedPref = flag.Bool("randseed", true, "Toggles random seed, used for debug/testing.")
+var connectedPref = flag.Int("connected", 0, "Checks variants for islands of pixels floating apart from the body, use 4 or 8 for the neighbors that count as touching, 0 to skip.")
+var islandsPref = flag.String("islands", "discard", "Sets what happens to variants with islands when checking connectivity. (discard; report)")
+var dedupePref = flag.String("dedupe", "", "Checks for variants that render the same as an earlier variant, use report to count them or remove to leave them off the spritesheet.")
+var mirrorDupesPref = flag.Bool("mirrordupes", false, "Counts flipped copies of an earlier variant as duplicates when using -dedupe, use Golang Bool values.")
+var orderPref = flag.String("order", "index", "Sets the order of variants on the spritesheet. (index; popcount; density; color; gray; similarity)")
+var indexedPref = flag.Bool("indexed", false, "Writes indexed (paletted) pngs holding the exact sprite colors, instead of 32-bit pngs, use Golang Bool values.")
+var sharedPalettePref = flag.Bool("sharedpalette", false, "Gives individual sprites the same palette as the spritesheet when writing indexed pngs, use Golang Bool values.")
+var roleMapPref = flag.Bool("rolemap", false, "Also writes the spritesheet in role index form, with a palette texture of each variant's colors, for palette swaps in shaders, use Golang Bool values.")
+var paddingPref = flag.Int("padding", 0, "Adds empty pixels around the edge of the spritesheet.")
+var spacingPref = flag.Int("spacing", 0, "Adds empty pixels between the images of the spritesheet.")
+var extrudePref = flag.Int("extrude", 0, "Copies the edge pixels of each image outward, so texture filtering doesn't bleed neighboring images together.")
+var powerOfTwoPref = flag.Bool("pot", false, "Rounds the spritesheet's width and height up to powers of two, use Golang Bool values.")
+var trimPref = flag.Bool("trim", false, "Crops the background from around each image on the spritesheet and packs them tighter, recording where they were cropped from in the metadata, use Golang Bool values.")
+var maxSizePref = flag.Int("maxsize", 0, "Splits the spritesheet into numbered pages no wider or taller than this many pixels, 0 for no limit.")
+var formatPref = flag.String("format", "png", "Sets the format images are written in. (png; qoi; webp-lossless; bmp; tga)")
+var svgPref = flag.String("svg", "", "Also writes .svg versions of the images, as rectangles of color. (sprites; sheet; all)")
+var archivePref = flag.String("archive", "", "Bundles everything we write into a single archive, instead of a folder of files. (zip; tar)")
+var sheetOutPref = flag.String("o", "", "Writes the spritesheet to this file instead, use - to write it to stdout.")
+var metadataPref = flag.Bool("metadata", false, "Writes a .json file describing where each variant sits on the spritesheet.")
+
+//Where we tell the user how things went.  That's stdout, unless the sprite sheet is going there.
+var messages io.Writer = os.Stdout
 
 //var cpuprofile = flag.String("cpuprofile", "", "Write cpu profile to file")
 
 func main() {
 	// //Profiling
-	flag.Parse()
+	//Segment colors (-color.2=) are pulled out first, since the flag package doesn't know about them.
+	args, segmentColorFlags, err := segmentColorArgs(os.Args[1:])
+	check(err)
+	flag.CommandLine.Parse(args)
+	messages = os.Stdout
+	if *sheetOutPref == "-" {
+		messages = os.Stderr
+	}
 	// if *cpuprofile != "" {
 	// 	f, err := os.Create(*cpuprofile)
 	// 	if err != nil {
@@ -97,12 +152,28 @@ func main() {
 	templateName := *templateString
 	folding := *foldPref
 	outlines := *outlinePref
+	style := outlineStyle{neighbors: *outlineNeighborsPref, thickness: *outlineThicknessPref, inner: *innerOutlinePref}
+	selout := *seloutPref
 	legacy := *legacyColors
 	upScale := *upscalePref
 	compositeWidth := *compositePref
 	outputName := *outputNamePref
 	individuals := *individualsPref
 	vertFold := *vertFoldPref
+	connectivity := *connectedPref
+	islandMode := *islandsPref
+	dedupe := *dedupePref
+	mirrorDupes := *mirrorDupesPref
+	order := *orderPref
+	writeMeta := *metadataPref
+	indexed := *indexedPref
+	sharedPalette := *sharedPalettePref
+	roleMap := *roleMapPref
+	trim := *trimPref
+	blendSpace := *blendSpacePref
+	easing := *easingPref
+	check(checkChoice("blendspace", blendSpace, "lab", "oklab", "rgb", "hsl"))
+	check(checkChoice("easing", easing, "linear", "in", "out", "inout"))
 
 	//We'll preemptively break down our colors strings as though they were blend values.  We'll use our
 	//enumerated Pixel values to put them on a temporary map.  Sorta chunky, but it's readable enough.
@@ -112,6 +183,84 @@ func main() {
 	chosenColorStrings[Fill] = strings.Split(*fillPref, ":")
 	chosenColorStrings[Background] = strings.Split(*backgroundPref, ":")
 	chosenColorStrings[Outline] = strings.Split(*outlineColorPref, ":")
+	chosenColorStrings[Off] = strings.Split(*offColorPref, ":")
+	//Remember which colors were passed in, since those win over anything we pick for the user.
+	explicitColors := make(map[Pixel]bool)
+	for key, val := range chosenColorStrings {
+		explicitColors[key] = val[0] != ""
+	}
+
+	//A palette fills in any colors we weren't given directly.
+	var palette []color.RGBA
+	if *palettePref != "" {
+		var err error
+		palette, err = readPalette(*palettePref)
+		check(err)
+		roles, err := paletteRoles(palette, *paletteRolesPref)
+		check(err)
+		for key, val := range roles {
+			if len(chosenColorStrings[key]) == 1 && chosenColorStrings[key][0] == "" {
+				chosenColorStrings[key] = strings.Split(val, ":")
+			}
+		}
+	}
+	paletteSnap := *paletteSnapPref && len(palette) > 0
+	harmony := *harmonyPref
+	check(checkChoice("offbits", *offBitsPref, "outline", "background", "fill", "off"))
+	offBits := map[string]Pixel{"outline": Outline, "background": Background, "fill": Fill, "off": Off}[strings.ToLower(*offBitsPref)]
+	shading := *shadingPref != ""
+	var light image.Point
+	if shading {
+		var err error
+		light, err = lightDirection(*shadingPref)
+		check(err)
+	}
+	shadeStrength := *shadeStrengthPref
+	if shadeStrength < 0 {
+		shadeStrength = 0
+	} else if shadeStrength > 1 {
+		shadeStrength = 1
+	}
+	if harmony != "" {
+		_, err := harmonize(White, harmony)
+		check(err)
+	}
+
+	//Random colors give every variant its own scheme.  We pick them all up front, so they don't depend on the
+	//order our goroutines happen to run in.
+	var randomColors []map[Pixel]color.Color
+	if *randomColorsPref != "" {
+		check(checkChoice("randomcolors", *randomColorsPref, "palette", "hsl"))
+		var err error
+		seed := *colorSeedPref
+		if seed == 0 {
+			seed = rand.Int63()
+		}
+		sampler := colorSampler{random: rand.New(rand.NewSource(seed))}
+		switch strings.ToLower(*randomColorsPref) {
+		case "palette":
+			if len(palette) == 0 {
+				log.Fatal("-randomcolors=palette needs a -palette to pick from")
+			}
+			sampler.palette = palette
+		case "hsl":
+			sampler.hue, err = parseRange(*huePref, 360)
+			check(err)
+			sampler.saturation, err = parseRange(*saturationPref, 1)
+			check(err)
+			sampler.lightness, err = parseRange(*lightnessPref, 1)
+			check(err)
+		}
+		randomColors = make([]map[Pixel]color.Color, 256)
+		for i := range randomColors {
+			randomColors[i] = sampler.scheme(harmony)
+		}
+	}
+	//Harmonized colors are kept on the palette when that's where our random colors come from.
+	harmonyPalette := palette
+	if !strings.EqualFold(*randomColorsPref, "palette") {
+		harmonyPalette = nil
+	}
 
 	//Converts those hexes into colors.
 	chosenColors := make(map[Pixel][]color.Color)
@@ -128,68 +277,96 @@ func main() {
 					chosenColors[key] = append(chosenColors[key], HGray)
 				case Background:
 					chosenColors[key] = append(chosenColors[key], Transp)
-				case Outline:
+				case Outline, Off:
 					chosenColors[key] = append(chosenColors[key], Black)
 				}
 			} else {
 				//Otherwise add one color to the chosen colors list
-				chosenColors[key] = append(chosenColors[key], gamut.Hex(val[0]))
+				chosenColors[key] = blendColors(val, blendSpace, easing)
 			}
 		} else {
-			//Add the blend to the list of chosen colors.  Should consider doing multiple blends.
-			chosenColors[key] = gamut.Blends(gamut.Hex(val[0]), gamut.Hex(val[1]), 256)
+			//Add the blend to the list of chosen colors, running through every color we were given.
+			chosenColors[key] = blendColors(val, blendSpace, easing)
 		}
 	}
 
 	//There's a few ways we can handle bad sheetwidth flags, defaulting to 16 is one solution.
-	if 256%compositeWidth != 0 || compositeWidth > 256 || compositeWidth < 1 {
+	if compositeWidth < 1 {
 		compositeWidth = 16
-		fmt.Print("Bad sheetWidth passed, defaulting to sheetWidth=16\n")
+		fmt.Fprint(messages, "Bad sheetWidth passed, defaulting to sheetWidth=16\n")
 	}
 
+	//Outlines either touch the sides of the body or the corners too, and are at least a pixel thick.
+	check(checkChoice("outlineneighbors", strconv.Itoa(style.neighbors), "4", "8"))
+	if style.thickness < 1 {
+		check(fmt.Errorf("-outlinethickness must be at least 1, got %v", style.thickness))
+	}
 	//sanitize upScale
 	if upScale < 1 {
 		upScale = 1
 	}
-	//Open the templateFile
+	scaler := *scalerPref
+	scaleFactor, err := scalerFactor(scaler)
+	check(err)
+	//Roles can't be mixed, so the role sheet can only follow a scaler that copies pixels.
+	if roleMap && scalerBlends(scaler) {
+		check(errors.New("-rolemap can't be used with -scaler=" + scaler + ", since it blends colors and roles can't be blended"))
+	}
+	format, err := lookupFormat(*formatPref)
+	check(err)
+	if indexed && format.extension != ".png" {
+		fmt.Fprint(messages, "Only pngs can be indexed, writing 32-bit images instead\n")
+		indexed = false
+	}
+	//SVG sprites go in the individuals folder, next to their pngs if we're writing those too.
+	svg := *svgPref
+	svgSprites := strings.EqualFold(svg, "sprites") || strings.EqualFold(svg, "all")
+	svgSheet := strings.EqualFold(svg, "sheet") || strings.EqualFold(svg, "all")
+	if svg != "" && !svgSprites && !svgSheet {
+		check(errors.New("unknown svg output " + svg + ", use sprites, sheet or all"))
+	}
+	//Islands are either 4 or 8 connected, and we either discard or report the variants that have them.
+	check(checkChoice("connected", strconv.Itoa(connectivity), "0", "4", "8"))
+	check(checkChoice("islands", islandMode, "discard", "report"))
+	//Duplicates are either reported or removed, and leaving -dedupe empty skips looking for them.
+	if dedupe != "" {
+		check(checkChoice("dedupe", dedupe, "report", "remove"))
+	}
+	//Square is the only aspect so far, and leaving it out keeps the columns as given.
+	if *aspectPref != "" {
+		check(checkChoice("aspect", *aspectPref, "square"))
+	}
+	//Open the template.  A composite template manifest (.json) takes precedence over a template png.  Templates
+	//are named after their file, whatever folder they're in.
 	currentDir, err := filepath.Abs("")
 	check(err)
-	templateFile, err := os.Open(filepath.Join(currentDir, "/Templates/"+templateName+".png"))
+	templatePath, err := findTemplate(templateName)
 	check(err)
-	defer templateFile.Close()
-
-	var dirString string
-	var PlacementDirectory string
-	var individualSpriteDir string
-	//Prepare the generation directories for the file here.
-	if strings.EqualFold(outputName, "docs") {
-		dirString = "docs/example"
-		PlacementDirectory = filepath.Join(currentDir, dirString)
-		mayCreateFolder(PlacementDirectory)
-		individualSpriteDir = filepath.Join(currentDir, dirString+"/Individuals")
-		if individuals {
-			mayCreateFolder(individualSpriteDir)
-		}
+	var templateManifest manifest
+	if strings.EqualFold(filepath.Ext(templatePath), ".json") {
+		templateManifest = readManifest(templatePath, filepath.Dir(templatePath))
 	} else {
-		if outputName != "" {
-			templateName = outputName
-		}
-		generationDirectory := filepath.Join(currentDir, "/GenerationDirectory")
-		mayCreateFolder(generationDirectory)
-		dirString = "GenerationDirectory/" + templateName
-		PlacementDirectory = filepath.Join(currentDir, dirString)
-		mayCreateFolder(PlacementDirectory)
-		individualSpriteDir = filepath.Join(currentDir, dirString+"/Individuals")
-		if individuals {
-			mayCreateFolder(individualSpriteDir)
-		}
+		templateManifest.template = readTemplate(templatePath)
 	}
+	tmpl := templateManifest.template
+	templateName = templateBaseName(templateName)
 
-	//Grab our template pixels and the template config
-	templateStream, err := png.Decode(templateFile)
+	//Prepare the generation directories for the file here.  Output goes in a folder of GenerationDirectory named
+	//after the template (or -outname), unless -outdir points somewhere else.  The folders themselves are made once
+	//we write to them.
+	namer, err := parseNamePattern(*namePatternPref)
 	check(err)
-	templateFile.Seek(0, 0)
-	templateConfig, err := png.DecodeConfig(templateFile)
+	namedTemplate := templateName
+	if outputName != "" {
+		templateName = outputName
+	}
+	PlacementDirectory := filepath.Join(currentDir, "GenerationDirectory", templateName)
+	if *outDirPref != "" {
+		PlacementDirectory, err = filepath.Abs(*outDirPref)
+		check(err)
+	}
+	individualSpriteDir := filepath.Join(PlacementDirectory, "Individuals")
+	out, err := newOutput(PlacementDirectory, *archivePref)
 	check(err)
 
 	//Use folding to determine the dimensions of the output images.
@@ -198,151 +375,134 @@ func main() {
 	var foldY int
 	var foldX int
 	if strings.EqualFold(folding, "even") || strings.EqualFold(folding, "e") {
-		canvasWidth = (templateConfig.Width * 2)
-		foldY = templateConfig.Width
+		canvasWidth = (tmpl.width * 2)
+		foldY = tmpl.width
 	} else if strings.EqualFold(folding, "odd") || strings.EqualFold(folding, "o") {
-		canvasWidth = ((templateConfig.Width * 2) - 1)
+		canvasWidth = ((tmpl.width * 2) - 1)
 		foldY = (canvasWidth / 2) + 1
 	} else {
-		canvasWidth = templateConfig.Width
+		canvasWidth = tmpl.width
 		foldY = canvasWidth
 	}
 
 	if strings.EqualFold(vertFold, "even") || strings.EqualFold(vertFold, "e") {
-		canvasHeight = templateConfig.Height * 2
-		foldX = templateConfig.Height
+		canvasHeight = tmpl.height * 2
+		foldX = tmpl.height
 	} else if strings.EqualFold(vertFold, "odd") || strings.EqualFold(vertFold, "o") {
-		canvasHeight = (templateConfig.Height * 2) - 1
+		canvasHeight = (tmpl.height * 2) - 1
 		foldX = (canvasHeight / 2) + 1
 	} else {
-		canvasHeight = templateConfig.Height
+		canvasHeight = tmpl.height
 		foldX = canvasHeight
 	}
 
-	//Translate the image into a simple array.
-	var templateArray []Pixel
-	var delimiters []int //indexes where we want to change our bit array
-	for y := 0; y < templateConfig.Height; y++ {
-		for x := 0; x < templateConfig.Width; x++ {
-			//Convert pixel model to RGBA.
-			aPixel := color.RGBAModel.Convert(templateStream.At(x, y))
-			//We compare the template's pixels to our defined colors, then append them to templateArray
-			switch aPixel {
-			case Red:
-				templateArray = append(templateArray, Outline)
-			case Green:
-				templateArray = append(templateArray, Accent)
-			case Blue:
-				templateArray = append(templateArray, Fill)
-			case Black:
-				templateArray = append(templateArray, Bit)
-			case Magenta:
-				templateArray = append(templateArray, Background)
-				delimiters = append(delimiters, x+y*templateConfig.Width)
-			default:
-				templateArray = append(templateArray, Background)
-			}
-		}
+	//Generate number list for delimited segments of the input image, or for each part of a composite template.
+	//When the manifest has bit rules we sample our numbers with them, otherwise we just shuffle.
+	delimiters := tmpl.delimiters
+	variantNumbers := make([]int, 256)
+	for i := range variantNumbers {
+		variantNumbers[i] = i
+	}
+	if templateManifest.Bits != nil && len(delimiters) == 0 && len(templateManifest.Parts) == 0 {
+		variantNumbers, err = templateManifest.Bits.sample(countBits(tmpl.pixels), 256)
+		check(err)
 	}
-
-	//Generate number list for delimited segments of the input image.
 	var randomArrays [][]int
+	segmentBits := tmpl.segmentBits()
 	for i := 0; i < len(delimiters); i++ {
-		randomArrays = append(randomArrays, rand.Perm(256))
+		if rules := templateManifest.segmentRules(i); rules != nil {
+			numbers, err := rules.sample(segmentBits[i], 256)
+			check(err)
+			randomArrays = append(randomArrays, numbers)
+		} else {
+			randomArrays = append(randomArrays, rand.Perm(256))
+		}
+	}
+	//Each delimited segment or part can have colors of its own.
+	segmentCount := len(delimiters)
+	if len(templateManifest.Parts) > 0 {
+		segmentCount = len(templateManifest.Parts)
+	}
+	if segmentCount == 0 {
+		segmentCount = 1
+	}
+	segmentOverrides, err := segmentColors(templateManifest, segmentCount, segmentColorFlags, blendSpace, easing)
+	check(err)
+	//Colors passed in, for the whole sprite or just this segment, win over anything we pick for the user.
+	explicit := func(key Pixel, segment int) bool {
+		return explicitColors[key] || segmentOverrides[segment][key] != nil
+	}
+	var partPresent [][]bool
+	for p, pt := range templateManifest.Parts {
+		if rules := templateManifest.partRules(p); rules != nil {
+			numbers, err := rules.sample(countBits(pt.template.pixels), 256)
+			check(err)
+			randomArrays = append(randomArrays, numbers)
+		} else {
+			randomArrays = append(randomArrays, rand.Perm(256))
+		}
+		partPresent = append(partPresent, make([]bool, 256))
+		for i := 0; i < 256; i++ {
+			partPresent[p][i] = !pt.Optional || rand.Float64() < pt.Chance
+		}
 	}
-	//composite is our sprite sheet, and we'll draw it up simultaneously with our individual images.
-	composite := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{canvasWidth * upScale * compositeWidth, canvasHeight * upScale * 256 / compositeWidth}})
-
 	//This is admittedly lazy, but as it stands I don't have a great solution in mind for scaling wait groups based on the pixels we write.  There is definitely a
 	//point where you gain some extra performance by using fewer wait groups that have responsibility for multiple images, but it's a little fuzzy and probably
 	//not worth the testing time and added code complexity to find those points.
 	var wg sync.WaitGroup
 	wg.Add(256)
 
+	//We resolve every variant first, then decide which ones make it onto the sheet.
+	variants := make([]variant, 256)
 	for i := 0; i < 256; i++ {
 		go func(i int) {
 			defer wg.Done()
+			//Grab this image's number for each segment.
+			segmentNumbers := make([]int, len(randomArrays))
+			for j := range randomArrays {
+				segmentNumbers[j] = randomArrays[j][i]
+			}
 			//newImage will hold a modified template array, based on how we read our bit pixels and our outline settings.
+			//Composite templates also tell us which part each pixel belongs to.
 			var newImage []Pixel
-			bitsRead := 0
-			resolutionNumber := i
-			delimitersRead := 0
-			for j := 0; j < len(templateArray); j++ {
-				if returnIndex(delimiters, j) != -1 {
-					delimitersRead = returnIndex(delimiters, j)
-					bitsRead = 0
-					resolutionNumber = randomArrays[delimitersRead][i]
-				}
-				if templateArray[j] == Bit {
-					//We take our increment, shift it by the bitsRead, finally checking whether it is even or odd.  This way 0 = all inactive,
-					//255 = all active.
-					if (resolutionNumber>>(bitsRead%8))&1 == 0 {
-						newImage = append(newImage, Outline)
-					} else {
-						newImage = append(newImage, Bit)
-					}
-					bitsRead++
-				} else {
-					newImage = append(newImage, templateArray[j])
+			var segments []int
+			if len(templateManifest.Parts) > 0 {
+				present := make([]bool, len(templateManifest.Parts))
+				for p := range templateManifest.Parts {
+					present[p] = partPresent[p][i]
 				}
-
+				newImage, segments = layerParts(templateManifest, segmentNumbers, present, offBits)
+			} else {
+				newImage = resolveBits(tmpl, variantNumbers[i], segmentNumbers, offBits)
 			}
-			//checks neighbors of active, colored pixels.  If the neighboring pixel is a background, replace it with an outline
-			//pixel.  Disabled by -outline=false
+			//Disabled by -outline=false
 			if outlines {
-				for j := 0; j < len(newImage); j++ {
-					if newImage[j] == Bit || newImage[j] == Fill || newImage[j] == Accent {
-						for k := -1; k < 2; k = k + 2 {
-							//left, right; the remainder of the index gives us an x coordinate
-							if templateConfig.Width > (j%templateConfig.Width)+k && (j%templateConfig.Width)+k >= 0 {
-								//if x-coord is good we can just add k to our index
-								if newImage[j+k] == Background {
-									newImage[j+k] = Outline
-								}
-							}
-							//up, down; use int()'s inherent round down ability to create our y coordinate
-							if templateConfig.Height > int(j/templateConfig.Width)+k && int(j/templateConfig.Width)+k >= 0 {
-								//looks gross, but it works.
-								yIndex := (j % templateConfig.Width) + ((int(j/templateConfig.Width) + k) * templateConfig.Width)
-								if newImage[yIndex] == Background {
-									newImage[yIndex] = Outline
-								}
-							}
-						}
-					}
-				}
+				outlinePixels(newImage, segments, tmpl.width, tmpl.height, style)
 			}
 			//TODO: Reduce Option. Here we would run through the image again to reduce
-			var outfile *os.File
-			var canvas *image.RGBA
-			if individuals {
-				// With the template adjusted, we create the output file for each image.
-				newFile := individualSpriteDir + "/" + strconv.Itoa(i) + ".png"
-				outfile, err = os.Create(newFile)
-				check(err)
-				canvas = image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{canvasWidth * upScale, canvasHeight * upScale}})
-			}
 
 			//let's grab the base color for our image
 			var finalColors [PixelsDefined][]color.Color
-			var placeholderIndex int
-			if len(delimiters) > 0 {
-				placeholderIndex = len(delimiters)
-			} else {
+			var resolutionNumber int
+			placeholderIndex := len(segmentNumbers)
+			if placeholderIndex == 0 {
 				placeholderIndex = 1
 			}
 			for j := 0; j < placeholderIndex; j++ {
-				if len(delimiters) == 0 {
-					resolutionNumber = i
+				if len(segmentNumbers) == 0 {
+					resolutionNumber = variantNumbers[i]
 				} else {
-					resolutionNumber = randomArrays[j][i]
+					resolutionNumber = segmentNumbers[j]
 				}
 				if !legacy {
 					for key, val := range chosenColors {
+						if override := segmentOverrides[j][key]; override != nil {
+							val = override
+						}
 						if len(val) > 1 {
-							finalColors[key] = append(finalColors[key], chosenColors[key][resolutionNumber])
+							finalColors[key] = append(finalColors[key], val[resolutionNumber])
 						} else {
-							finalColors[key] = append(finalColors[key], chosenColors[key][0])
+							finalColors[key] = append(finalColors[key], val[0])
 						}
 					}
 				} else {
@@ -352,37 +512,88 @@ func main() {
 					finalColors[Fill] = append(finalColors[Fill], color.YCbCr{192, uint8((resolutionNumber + 128) % 256), uint8(resolutionNumber % 256)})
 					finalColors[Background] = append(finalColors[Background], Transp)
 					finalColors[Outline] = append(finalColors[Outline], Black)
+					finalColors[Off] = append(finalColors[Off], Black)
 				}
 			}
+			//Random colors replace any bit, accent and fill colors that weren't passed in.
+			if randomColors != nil {
+				for key, c := range randomColors[i] {
+					for j := range finalColors[key] {
+						if !explicit(key, j) {
+							finalColors[key][j] = c
+						}
+					}
+				}
+			}
+			//A harmony works out the rest of the colors from each bit color, even when the bit color is a blend.
+			if harmony != "" {
+				for j := range finalColors[Bit] {
+					derived, _ := harmonize(finalColors[Bit][j], harmony)
+					for key, c := range derived {
+						if explicit(key, j) {
+							continue
+						}
+						if harmonyPalette != nil {
+							c = nearestColor(c, harmonyPalette)
+						}
+						finalColors[key][j] = c
+					}
+				}
+			}
+			//Inactive bits follow the outlines, unless they were given their own color.
+			for j := range finalColors[Off] {
+				if !explicit(Off, j) {
+					finalColors[Off][j] = finalColors[Outline][j]
+				}
+			}
+			//Keep everything on palette, if asked.
+			if paletteSnap {
+				for key := range finalColors {
+					for j := range finalColors[key] {
+						finalColors[key][j] = nearestColor(finalColors[key][j], palette)
+					}
+				}
+			}
+			v := variant{index: i, numbers: segmentNumbers, colors: finalColors}
+			if len(segmentNumbers) == 0 {
+				v.numbers = []int{variantNumbers[i]}
+			}
 
-			//Finally, with colors and a template secured, we can write to our individual canvas and collective composite.
+			//Finally, with colors and a template secured, we can unfold the template and color in our sprite.
+			v.roles = make([]Pixel, canvasWidth*canvasHeight)
+			v.segments = make([]int, canvasWidth*canvasHeight)
+			v.sprite = image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{canvasWidth, canvasHeight}})
 			var pixelIndex int
+			delimitersRead := 0
 			for y := 0; y < canvasHeight; y++ {
 				for x := 0; x < canvasWidth; x++ {
 					//We want to start by converting our coordinate into an index position.  When we fold,
 					//we put our index at the mirrored position.
 					if x < foldY {
 						if y < foldX {
-							pixelIndex = x + (y * templateConfig.Width)
+							pixelIndex = x + (y * tmpl.width)
 						} else {
-							pixelIndex = x + ((canvasHeight - y - 1) * templateConfig.Width)
+							pixelIndex = x + ((canvasHeight - y - 1) * tmpl.width)
 						}
 					} else {
 						if y < foldX {
-							pixelIndex = (canvasWidth - x) + (y * templateConfig.Width) - 1
+							pixelIndex = (canvasWidth - x) + (y * tmpl.width) - 1
 						} else {
-							pixelIndex = (canvasWidth - x) + ((canvasHeight - y - 1) * templateConfig.Width) - 1
+							pixelIndex = (canvasWidth - x) + ((canvasHeight - y - 1) * tmpl.width) - 1
 						}
 					}
 
-					if y < foldX {
+					if segments != nil {
+						//composite templates already know which part each pixel is from.
+						delimitersRead = segments[pixelIndex]
+					} else if y < foldX {
 						if returnIndex(delimiters, pixelIndex) != -1 {
 							delimitersRead = returnIndex(delimiters, pixelIndex)
 						}
 					} else {
 						//when we flip, we need to consider that we're reading upside down, so adjust
 						// our pixel index down
-						modifiedPixelIndex := x + ((canvasHeight - y) * templateConfig.Width)
+						modifiedPixelIndex := x + ((canvasHeight - y) * tmpl.width)
 						if returnIndex(delimiters, modifiedPixelIndex) != -1 {
 							delimitersRead = returnIndex(delimiters, modifiedPixelIndex) - 1
 						} //Hacky hack for reading that last delimiter
@@ -391,33 +602,186 @@ func main() {
 						}
 					}
 
-					//A little messy, but we account for upScale here.
-					for j := 0; j < upScale; j++ {
-						for k := 0; k < upScale; k++ {
-							if individuals {
-								canvas.Set((x*upScale)+j, (y*upScale)+k,
-									finalColors[newImage[pixelIndex]][delimitersRead])
-							}
-							composite.Set((x*upScale)+j+canvasWidth*upScale*(i%compositeWidth),
-								(y*upScale)+k+canvasHeight*upScale*(i/compositeWidth),
-								finalColors[newImage[pixelIndex]][delimitersRead])
-						}
-					}
+					v.roles[x+y*canvasWidth] = newImage[pixelIndex]
+					v.segments[x+y*canvasWidth] = delimitersRead
+					v.sprite.Set(x, y, finalColors[newImage[pixelIndex]][delimitersRead])
 				}
 			}
-			//After building the sprite, we encode, then close the individual sprite file.
-			if individuals {
-				png.Encode(outfile, canvas)
-				outfile.Close()
+			if outlines && selout {
+				selectiveOutline(v.sprite, v.roles, .4)
+			}
+			//Shading works on the unfolded sprite, so the light falls the same way across both halves.
+			if shading {
+				shadeSprite(v.sprite, v.roles, light, shadeStrength)
 			}
+			variants[i] = v
 		}(i)
 	}
 	wg.Wait()
-	compositeName := PlacementDirectory + "/" + templateName + "SpriteSheet.png"
-	compositeFile, err := os.Create(compositeName)
+
+	//Check for pieces floating away from the body of our sprites.  Disabled by -connected=0
+	if connectivity == 4 || connectivity == 8 {
+		variants = filterIslands(variants, canvasWidth, canvasHeight, connectivity, islandMode)
+		if len(variants) == 0 {
+			log.Fatal("No variants left to place on the sprite sheet")
+		}
+	}
+	//Folding and short templates can render the same sprite more than once.  Disabled by -dedupe=
+	if strings.EqualFold(dedupe, "report") || strings.EqualFold(dedupe, "remove") {
+		variants = findDuplicates(variants, dedupe, mirrorDupes)
+	}
+	variants, err = orderVariants(variants, order)
 	check(err)
-	png.Encode(compositeFile, composite)
-	compositeFile.Close()
+
+	//composite is our sprite sheet, which is filled with whichever variants are left.  We write our individual images
+	//while we're at it.
+	layout := sheetLayout{
+		cellWidth:  canvasWidth * scaleFactor * upScale,
+		cellHeight: canvasHeight * scaleFactor * upScale,
+		padding:    *paddingPref,
+		spacing:    *spacingPref,
+		extrude:    *extrudePref,
+		powerOfTwo: *powerOfTwoPref,
+	}
+	//negative margins don't make much sense, so we just drop them.
+	for _, margin := range []*int{&layout.padding, &layout.spacing, &layout.extrude} {
+		if *margin < 0 {
+			*margin = 0
+		}
+	}
+	layout.columns = sheetColumns(len(variants), compositeWidth, *sheetHeightPref, *aspectPref, layout.cellWidth, layout.cellHeight)
+	//Our sprites at their final size, and the part of each that goes on the sheet, which is all of it unless we trim.
+	scaledSprites := make([]*image.RGBA, len(variants))
+	trims := make([]image.Rectangle, len(variants))
+	for n, v := range variants {
+		scaledSprites[n] = upscaleSprite(scaleSprite(v.sprite, scaler), upScale)
+		trims[n] = scaledSprites[n].Bounds()
+	}
+	//Trimmed sprites come in all sizes, so they're packed onto shelves instead of a grid.  Either way, sheets that
+	//would be bigger than -maxsize are split into pages.
+	var sheet packing
+	if trim {
+		var sizes []image.Point
+		for n, v := range variants {
+			trims[n] = trimBounds(scaledSprites[n], v.colors[Background])
+			sizes = append(sizes, trims[n].Size())
+		}
+		sheet, err = layout.shelves(sizes, *maxSizePref)
+		check(err)
+	} else {
+		perPage := len(variants)
+		if *maxSizePref > 0 {
+			perPage, err = layout.fit(*maxSizePref)
+			check(err)
+		}
+		sheet = layout.grid(len(variants), perPage)
+	}
+	pages := len(sheet.pageSizes)
+	//-o takes the sheet somewhere else, outside of any archive, so it can only hold one page.
+	sheetOut := *sheetOutPref
+	if sheetOut != "" && pages > 1 {
+		check(errors.New("-o can only take a single page sheet, so it can't be used with a sheet split by -maxsize"))
+	}
+	metadata := sheetMetadata{Padding: layout.padding, Spacing: layout.spacing, Extrude: layout.extrude}
+	//Indexed pngs need a palette.  The sheet's palette holds every color we placed, and individuals either share it,
+	//or get a palette of their own.  If we've got more colors than a png palette holds, we stick with 32-bit pngs.
+	var sheetPalette color.Palette
+	if indexed {
+		var fits bool
+		sheetPalette, fits = collectPalette(scaledSprites)
+		if !fits {
+			fmt.Fprintf(messages, "The sprite sheet uses %v colors, more than an indexed png can hold, writing 32-bit pngs instead\n", len(sheetPalette))
+			sheetPalette = nil
+		}
+	}
+	if roleMap && (shading || (outlines && selout)) {
+		fmt.Fprint(messages, "Shading and selout colors aren't part of the palette texture, so the role sheet won't show them\n")
+	}
+	for page := 0; page < pages; page++ {
+		composite := image.NewRGBA(image.Rectangle{image.Point{0, 0}, sheet.pageSizes[page]})
+		//Pages are only numbered when there's more than one.
+		suffix := format.extension
+		if pages > 1 {
+			suffix = "_" + strconv.Itoa(page) + format.extension
+		}
+		compositeName := templateName + "SpriteSheet" + suffix
+		if page == 0 {
+			metadata.Image, metadata.Width, metadata.Height = compositeName, composite.Bounds().Dx(), composite.Bounds().Dy()
+			if sheetOut != "" {
+				metadata.Image = sheetReference(PlacementDirectory, sheetOut)
+			}
+		}
+		for n, v := range variants {
+			if sheet.pages[n] != page {
+				continue
+			}
+			scaled := scaledSprites[n]
+			placement := sheet.placements[n]
+			placeSprite(composite, placement, scaled.SubImage(trims[n]).(*image.RGBA), layout.extrude)
+			f := frame{Index: v.index, X: placement.Min.X, Y: placement.Min.Y, W: placement.Dx(), H: placement.Dy(), Page: page, Islands: v.islands, DuplicateOf: v.original}
+			if trim {
+				f.Trimmed = trims[n] != scaled.Bounds()
+				f.SourceX, f.SourceY, f.SourceW, f.SourceH = trims[n].Min.X, trims[n].Min.Y, scaled.Bounds().Dx(), scaled.Bounds().Dy()
+			}
+			metadata.Frames = append(metadata.Frames, f)
+			//After building the sprite, we encode, then close the individual sprite file.
+			if individuals {
+				var spritePalette color.Palette
+				if indexed && sharedPalette && sheetPalette != nil {
+					spritePalette = sheetPalette
+				} else if indexed {
+					if own, fits := collectPalette([]*image.RGBA{scaled}); fits {
+						spritePalette = own
+					}
+				}
+				writeImage(out, individualSpriteDir+"/"+namer.name(namedTemplate, v)+format.extension, scaled, spritePalette, format)
+			}
+			//SVGs scale up with their viewBox, rather than bigger pixels.
+			if svgSprites {
+				writeSVG(out, individualSpriteDir+"/"+namer.name(namedTemplate, v)+".svg", scaleSprite(v.sprite, scaler), upScale)
+			}
+		}
+		if sheetOut != "" {
+			writeImage(&output{}, sheetOut, composite, sheetPalette, format)
+		} else {
+			writeImage(out, PlacementDirectory+"/"+compositeName, composite, sheetPalette, format)
+		}
+		if svgSheet {
+			writeSVG(out, PlacementDirectory+"/"+strings.TrimSuffix(compositeName, format.extension)+".svg", composite, 1)
+		}
+		//The role sheet shares the sprite sheet's layout, trimmed the same way.
+		var roleName string
+		if roleMap {
+			roleSheet := image.NewRGBA(composite.Bounds())
+			for n, v := range variants {
+				if sheet.pages[n] == page {
+					roles := upscaleSprite(scaleAlong(v.sprite, roleSprite(v), scaler), upScale)
+					placeSprite(roleSheet, sheet.placements[n], roles.SubImage(trims[n]).(*image.RGBA), layout.extrude)
+				}
+			}
+			roleName = templateName + "RoleSheet" + suffix
+			writeImage(out, PlacementDirectory+"/"+roleName, roleSheet, nil, format)
+			if page == 0 {
+				metadata.RoleImage = roleName
+			}
+		}
+		if pages > 1 {
+			metadata.Pages = append(metadata.Pages, compositeName)
+			if roleMap {
+				metadata.RolePages = append(metadata.RolePages, roleName)
+			}
+		}
+	}
+	//The palette texture has a row for each frame, across every page.
+	if roleMap {
+		metadata.PaletteImage = templateName + "Palette" + format.extension
+		metadata.Roles = roleNames
+		writeImage(out, PlacementDirectory+"/"+metadata.PaletteImage, paletteTexture(variants), nil, format)
+	}
+	if writeMeta {
+		writeMetadata(out, PlacementDirectory+"/"+templateName+"SpriteSheet.json", metadata)
+	}
+	out.close()
 }
 
 //Very generic check function to reduce boilerplate.  Since we are creating files, I figure we err on the side of caution and
@@ -428,17 +792,14 @@ func check(err error) {
 	}
 }
 
-//Creates folder if it does not already exist.
-func mayCreateFolder(path string) {
-	_, err := os.Stat(path)
-	if err == nil {
-		//folder exists
-	} else if errors.Is(err, os.ErrNotExist) {
-		os.Mkdir(path, 0755)
-	} else {
-		//Shadow realm
-		log.Fatal(err)
+//Makes sure a flag was given one of the values it understands, ignoring case.
+func checkChoice(name, value string, choices ...string) error {
+	for _, choice := range choices {
+		if strings.EqualFold(value, choice) {
+			return nil
+		}
 	}
+	return fmt.Errorf("unknown -%v value %q, use %v", name, value, strings.Join(choices, ", "))
 }
 
 // return index of matched value, otherwise return -1
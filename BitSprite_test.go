@@ -1,16 +1,26 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"image"
+	"image/color"
 	"image/png"
+	"io"
+	"math/bits"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
 //We're going to compare with fresh images with ones previously prepared.  One annoying thing
-//is that flags appear to persist between test functions, so there are redundant Args.
+//is that flags appear to persist between test functions, so Compare resets them to their defaults
+//before each run.  Older tests still carry some redundant Args from before that.
 func TestDefault(t *testing.T) {
 	fmt.Printf("TestDefault\n")
 	gotFileName := "GenerationDirectory/Triangle/TriangleSpriteSheet.png"
@@ -210,6 +220,509 @@ func TestFace(t *testing.T) {
 	Compare(t, gotFileName, wantFileName, testArgs)
 }
 
+func TestCompositeTemplate(t *testing.T) {
+	fmt.Printf("TestCompositeTemplate\n")
+	gotFileName := "/GenerationDirectory/FlowerParts/FlowerPartsSpriteSheet.png"
+	wantFileName := "/testResources/FlowerParts.png"
+	testArgs := []string{"cmd", "-template=FlowerParts", "-fold=o", "-legacy=t", "-randseed=f"}
+	Compare(t, gotFileName, wantFileName, testArgs)
+}
+
+//FaceBits' rules need at least 3 bits on, bit 4 whenever bit 3 is on, and never both bits 1 and 2.  The individuals
+//are named after their bits, so we can check every variant we drew follows them.
+func TestManifestRules(t *testing.T) {
+	fmt.Printf("TestManifestRules\n")
+	resetFlags()
+	os.RemoveAll("GenerationDirectory/FaceBits")
+	os.Args = []string{"cmd", "-template=FaceBits", "-metadata=t", "-individuals", "-namepattern={index}_{bits}"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/FaceBits/FaceBitsSpriteSheet.json")
+	if len(metadata.Frames) != 256 {
+		t.Fatalf("Got %v frames, wanted 256", len(metadata.Frames))
+	}
+	files, err := os.ReadDir("GenerationDirectory/FaceBits/Individuals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(metadata.Frames) {
+		t.Fatalf("Got %v individuals for %v frames", len(files), len(metadata.Frames))
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".png")
+		bits := name[strings.Index(name, "_")+1:]
+		//bit 0 is the last digit
+		on := func(b int) bool { return bits[7-b] == '1' }
+		if strings.Count(bits, "1") < 3 || (on(3) && !on(4)) || (on(1) && on(2)) {
+			t.Errorf("Variant %v breaks the manifest's rules", name)
+		}
+	}
+}
+
+func TestConnectivity(t *testing.T) {
+	fmt.Printf("TestConnectivity\n")
+	gotFileName := "/GenerationDirectory/Triangle/TriangleSpriteSheet.png"
+	wantFileName := "/testResources/TriangleSSConnected.png"
+	testArgs := []string{"cmd", "-template=Triangle", "-connected=4", "-metadata=t"}
+	Compare(t, gotFileName, wantFileName, testArgs)
+
+	//Only the variants in one piece should be listed, by their original index.
+	metadata := readTestMetadata(t, "GenerationDirectory/Triangle/TriangleSpriteSheet.json")
+	if len(metadata.Frames) != 90 {
+		t.Fatalf("Got %v frames, wanted 90", len(metadata.Frames))
+	}
+	if metadata.Frames[1].Index != 4 || metadata.Frames[1].X != 6 {
+		t.Fatalf("Wanted variant 4 in the second cell, got %+v", metadata.Frames[1])
+	}
+}
+
+//FlowerHead only has 7 bits, so half of the variants repeat the other half.
+func TestDedupe(t *testing.T) {
+	fmt.Printf("TestDedupe\n")
+	gotFileName := "/GenerationDirectory/FlowerHead/FlowerHeadSpriteSheet.png"
+	wantFileName := "/testResources/FlowerHeadDeduped.png"
+	testArgs := []string{"cmd", "-template=FlowerHead", "-dedupe=remove", "-mirrordupes=t", "-metadata=t"}
+	Compare(t, gotFileName, wantFileName, testArgs)
+
+	metadata := readTestMetadata(t, "GenerationDirectory/FlowerHead/FlowerHeadSpriteSheet.json")
+	if len(metadata.Frames) != 128 {
+		t.Fatalf("Got %v frames, wanted 128", len(metadata.Frames))
+	}
+}
+
+func TestMirrorDuplicates(t *testing.T) {
+	fmt.Printf("TestMirrorDuplicates\n")
+	a := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	a.Set(0, 0, Red)
+	b := flipSprite(a, true)
+	variants := []variant{{index: 0, sprite: a}, {index: 1, sprite: b}}
+	if got := findDuplicates(variants, "report", false); got[1].original != nil {
+		t.Fatalf("Variant 1 shouldn't be a duplicate without mirrors")
+	}
+	got := findDuplicates(variants, "report", true)
+	if got[1].original == nil || *got[1].original != 0 {
+		t.Fatalf("Wanted variant 1 to be a mirror of variant 0")
+	}
+	if got := findDuplicates(variants, "remove", true); len(got) != 1 {
+		t.Fatalf("Got %v variants after removing mirrors, wanted 1", len(got))
+	}
+}
+
+func TestOrderGray(t *testing.T) {
+	fmt.Printf("TestOrderGray\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=Triangle", "-order=gray", "-metadata=t"}
+	main()
+	//neighbors on the sheet should only differ by one bit.
+	metadata := readTestMetadata(t, "GenerationDirectory/Triangle/TriangleSpriteSheet.json")
+	for k, f := range metadata.Frames {
+		if f.Index != k^(k>>1) {
+			t.Fatalf("Got variant %v in cell %v, wanted %v", f.Index, k, k^(k>>1))
+		}
+	}
+}
+
+//With two segments, the whole sheet should still only change one bit at a time, across segment boundaries too.
+func TestOrderGraySegments(t *testing.T) {
+	fmt.Printf("TestOrderGraySegments\n")
+	var variants []variant
+	for i := 0; i < 1<<16; i++ {
+		variants = append(variants, variant{index: i, numbers: []int{i >> 8, i & 0xff}})
+	}
+	got, err := orderVariants(variants, "gray")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 1; k < len(got); k++ {
+		a, b := got[k-1].numbers, got[k].numbers
+		if changed := bits.OnesCount(uint((a[0]^b[0])<<8 | (a[1] ^ b[1]))); changed != 1 {
+			t.Fatalf("Cells %v and %v differ by %v bits, %v and %v", k-1, k, changed, a, b)
+		}
+	}
+	if _, err := orderVariants(variants, "grey"); err == nil {
+		t.Fatalf("Wanted an error for an unknown order")
+	}
+}
+
+//Four small variants, a row of three pixels each, with transparent backgrounds: a red dot, a row of blue, a pair
+//of green, and a pair of dark red.
+func orderTestVariants() []variant {
+	red, blue, green, darkRed := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{128, 0, 0, 255}
+	rows := [][]color.RGBA{{red, {}, {}}, {blue, blue, blue}, {green, green, {}}, {darkRed, darkRed, {}}}
+	var variants []variant
+	for i, row := range rows {
+		v := variant{index: i, numbers: []int{i}, sprite: image.NewRGBA(image.Rect(0, 0, 3, 1))}
+		for x, c := range row {
+			v.sprite.SetRGBA(x, 0, c)
+			if c.A == 0 {
+				v.roles = append(v.roles, Background)
+			} else {
+				v.roles = append(v.roles, Bit)
+			}
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+func TestOrderProperties(t *testing.T) {
+	fmt.Printf("TestOrderProperties\n")
+	cases := map[string][]int{
+		//fewest pixels first, keeping index order for ties
+		"density": {0, 2, 3, 1},
+		//by hue, red before green before blue, with darker colors first within a hue
+		"color": {3, 0, 2, 1},
+		//red dot, then the closest looking dark red pair, then the green pair, then the blue row
+		"similarity": {0, 3, 2, 1},
+		"index":      {0, 1, 2, 3},
+	}
+	for order, want := range cases {
+		got, err := orderVariants(orderTestVariants(), order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, v := range got {
+			if v.index != want[j] {
+				t.Errorf("%v order put variant %v in place %v, want %v", order, v.index, j, want)
+				break
+			}
+		}
+	}
+}
+
+func TestOrderPopcount(t *testing.T) {
+	fmt.Printf("TestOrderPopcount\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=Triangle", "-order=popcount", "-metadata=t"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/Triangle/TriangleSpriteSheet.json")
+	if metadata.Frames[0].Index != 0 || metadata.Frames[255].Index != 255 || metadata.Frames[1].Index != 1 || metadata.Frames[9].Index != 3 {
+		t.Fatalf("Variants aren't ordered by popcount, got %+v", metadata.Frames[:10])
+	}
+}
+
+func TestIndexed(t *testing.T) {
+	fmt.Printf("TestIndexed\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-indexed", "-individuals", "-sharedpalette", "-outname=TriangleIndexed"}
+	main()
+	sheet := decodeTestPNG(t, "GenerationDirectory/TriangleIndexed/TriangleIndexedSpriteSheet.png")
+	paletted, ok := sheet.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Got a %T, wanted an indexed png", sheet)
+	}
+	//indexed or not, the colors should be exactly the same
+	want := decodeTestPNG(t, "testResources/TriangleSSVanilla.png")
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			r1, g1, b1, a1 := want.At(x, y).RGBA()
+			r2, g2, b2, a2 := sheet.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("Wanted color %v at point %v,%v; got color %v", want.At(x, y), x, y, sheet.At(x, y))
+			}
+		}
+	}
+	individual, ok := decodeTestPNG(t, "GenerationDirectory/TriangleIndexed/Individuals/127.png").(*image.Paletted)
+	if !ok || len(individual.Palette) != len(paletted.Palette) {
+		t.Errorf("Individuals should share the sprite sheet's palette")
+	}
+}
+
+func decodeTestPNG(t *testing.T, fileName string) image.Image {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRoleMap(t *testing.T) {
+	fmt.Printf("TestRoleMap\n")
+	if len(roleNames) != int(PixelsDefined) {
+		t.Fatalf("Every role needs a name, got %v names for %v roles", len(roleNames), PixelsDefined)
+	}
+	resetFlags()
+	os.Args = []string{"cmd", "-template=flowerDelimited", "-fold=o", "-color=#ff0000:#0000ff", "-fill.1=#00ff00", "-rolemap", "-metadata", "-upscale=2", "-outname=FlowerRoles"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/FlowerRoles/FlowerRolesSpriteSheet.json")
+	if metadata.RoleImage != "FlowerRolesRoleSheet.png" || metadata.PaletteImage != "FlowerRolesPalette.png" || len(metadata.Roles) != int(PixelsDefined) {
+		t.Fatalf("Metadata should point at the role sheet and palette texture, got %+v", metadata)
+	}
+	sheet := decodeTestPNG(t, "GenerationDirectory/FlowerRoles/FlowerRolesSpriteSheet.png")
+	roles := decodeTestPNG(t, "GenerationDirectory/FlowerRoles/FlowerRolesRoleSheet.png")
+	palette := decodeTestPNG(t, "GenerationDirectory/FlowerRoles/FlowerRolesPalette.png")
+	if palette.Bounds().Dy() != len(metadata.Frames) {
+		t.Fatalf("The palette texture should have a row per frame, got %v rows", palette.Bounds().Dy())
+	}
+	//looking up every pixel of the role sheet in the palette texture should give us back the sprite sheet
+	for row, f := range metadata.Frames {
+		for y := f.Y; y < f.Y+f.H; y++ {
+			for x := f.X; x < f.X+f.W; x++ {
+				role, segment, _, _ := roles.At(x, y).RGBA()
+				r1, g1, b1, a1 := palette.At(int(segment>>8)*int(PixelsDefined)+int(role>>8), row).RGBA()
+				r2, g2, b2, a2 := sheet.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+					t.Fatalf("Frame %v at %v,%v looked up %v, but the sheet has %v", row, x, y, palette.At(int(segment>>8)*int(PixelsDefined)+int(role>>8), row), sheet.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestSheetMargins(t *testing.T) {
+	fmt.Printf("TestSheetMargins\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-padding=2", "-spacing=1", "-extrude=1", "-pot", "-metadata", "-outname=TriangleMargins"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/TriangleMargins/TriangleMarginsSpriteSheet.json")
+	sheet := decodeTestPNG(t, "GenerationDirectory/TriangleMargins/TriangleMarginsSpriteSheet.png")
+	want := decodeTestPNG(t, "testResources/TriangleSSVanilla.png")
+	w, h := metadata.Frames[0].W, metadata.Frames[0].H
+	if sheet.Bounds().Dx() != nextPowerOfTwo(2*2+16*(w+2)+15) || sheet.Bounds().Dy() != nextPowerOfTwo(2*2+16*(h+2)+15) {
+		t.Fatalf("Got a %v sheet", sheet.Bounds())
+	}
+	if metadata.Padding != 2 || metadata.Spacing != 1 || metadata.Extrude != 1 {
+		t.Errorf("Metadata should record our margins, got %+v", metadata)
+	}
+	sameColor := func(a, b color.Color) bool {
+		r1, g1, b1, a1 := a.RGBA()
+		r2, g2, b2, a2 := b.RGBA()
+		return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+	}
+	for n, f := range metadata.Frames {
+		if f.X != 2+1+(n%16)*(w+3) || f.Y != 2+1+(n/16)*(h+3) {
+			t.Fatalf("Frame %v is at %v,%v", n, f.X, f.Y)
+		}
+		//each frame should match the unpadded sheet, with its edges copied outward
+		for y := -1; y <= h; y++ {
+			for x := -1; x <= w; x++ {
+				wx, wy := w*(n%16)+clamp(x, 0, w-1), h*(n/16)+clamp(y, 0, h-1)
+				if !sameColor(sheet.At(f.X+x, f.Y+y), want.At(wx, wy)) {
+					t.Fatalf("Frame %v has color %v at %v,%v, wanted %v", n, sheet.At(f.X+x, f.Y+y), x, y, want.At(wx, wy))
+				}
+			}
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	fmt.Printf("TestMaxSize\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-maxsize=64", "-metadata", "-outname=TrianglePages"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/TrianglePages/TrianglePagesSpriteSheet.json")
+	if len(metadata.Pages) < 2 || metadata.Pages[1] != "TrianglePagesSpriteSheet_1.png" || metadata.Image != metadata.Pages[0] {
+		t.Fatalf("The sheet should be split into numbered pages, got %v", metadata.Pages)
+	}
+	want := decodeTestPNG(t, "testResources/TriangleSSVanilla.png")
+	pages := make([]image.Image, len(metadata.Pages))
+	for p, name := range metadata.Pages {
+		pages[p] = decodeTestPNG(t, "GenerationDirectory/TrianglePages/"+name)
+		if pages[p].Bounds().Dx() > 64 || pages[p].Bounds().Dy() > 64 {
+			t.Errorf("Page %v is %v, bigger than our max size", p, pages[p].Bounds())
+		}
+	}
+	//every frame should still look like the same cell on the unsplit sheet
+	for n, f := range metadata.Frames {
+		if f.Page < 0 || f.Page >= len(pages) || (n > 0 && f.Page < metadata.Frames[n-1].Page) {
+			t.Fatalf("Frame %v is on page %v", n, f.Page)
+		}
+		for y := 0; y < f.H; y++ {
+			for x := 0; x < f.W; x++ {
+				r1, g1, b1, a1 := pages[f.Page].At(f.X+x, f.Y+y).RGBA()
+				r2, g2, b2, a2 := want.At(f.W*(n%16)+x, f.H*(n/16)+y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+					t.Fatalf("Frame %v doesn't match the unsplit sheet at %v,%v", n, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	fmt.Printf("TestTrim\n")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-trim", "-metadata", "-outname=TriangleTrim"}
+	main()
+	metadata := readTestMetadata(t, "GenerationDirectory/TriangleTrim/TriangleTrimSpriteSheet.json")
+	sheet := decodeTestPNG(t, "GenerationDirectory/TriangleTrim/"+metadata.Image)
+	want := decodeTestPNG(t, "testResources/TriangleSSVanilla.png")
+	cellWidth, cellHeight := want.Bounds().Dx()/16, want.Bounds().Dy()/16
+	if sheet.Bounds().Dx() > want.Bounds().Dx() || sheet.Bounds().Dy() >= want.Bounds().Dy() {
+		t.Errorf("A trimmed sheet should be smaller, got %v", sheet.Bounds())
+	}
+	//each frame, put back at its source offset, should match the untrimmed sprite
+	for n, f := range metadata.Frames {
+		if f.SourceW != cellWidth || f.SourceH != cellHeight || f.SourceX+f.W > f.SourceW || f.SourceY+f.H > f.SourceH {
+			t.Fatalf("Frame %v has a bad source rectangle %+v", n, f)
+		}
+		for y := 0; y < f.H; y++ {
+			for x := 0; x < f.W; x++ {
+				r1, g1, b1, a1 := sheet.At(f.X+x, f.Y+y).RGBA()
+				r2, g2, b2, a2 := want.At(cellWidth*(n%16)+f.SourceX+x, cellHeight*(n/16)+f.SourceY+y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+					t.Fatalf("Frame %v doesn't match the untrimmed sprite at %v,%v", n, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	fmt.Printf("TestArchive\n")
+	want := decodeTestPNG(t, "testResources/TriangleSSVanilla.png")
+	for _, kind := range []string{"zip", "tar"} {
+		os.RemoveAll("GenerationDirectory/TriangleArchive")
+		resetFlags()
+		os.Args = []string{"cmd", "-template=triangle", "-archive=" + kind, "-individuals", "-metadata", "-outname=TriangleArchive"}
+		main()
+		if _, err := os.Stat("GenerationDirectory/TriangleArchive"); err == nil {
+			t.Errorf("Archiving as %v shouldn't make a folder", kind)
+		}
+		files := make(map[string][]byte)
+		if kind == "zip" {
+			archive, err := zip.OpenReader("GenerationDirectory/TriangleArchive.zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range archive.File {
+				contents, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[f.Name], _ = io.ReadAll(contents)
+				contents.Close()
+			}
+			archive.Close()
+		} else {
+			archive, err := os.Open("GenerationDirectory/TriangleArchive.tar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents := tar.NewReader(archive)
+			for {
+				header, err := contents.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				files[header.Name], _ = io.ReadAll(contents)
+			}
+			archive.Close()
+		}
+		//the sheet, its metadata and 256 individuals
+		if len(files) != 258 || files["TriangleArchive/TriangleArchiveSpriteSheet.json"] == nil || files["TriangleArchive/Individuals/127.png"] == nil {
+			t.Fatalf("Unexpected %v of %v files", kind, len(files))
+		}
+		sheet, err := png.Decode(bytes.NewReader(files["TriangleArchive/TriangleArchiveSpriteSheet.png"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameTestImage(t, sheet, want)
+	}
+}
+
+func TestStdout(t *testing.T) {
+	fmt.Printf("TestStdout\n")
+	os.RemoveAll("GenerationDirectory/TriangleStdout")
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := make(chan []byte)
+	go func() {
+		contents, _ := io.ReadAll(read)
+		sheet <- contents
+	}()
+	stdout := os.Stdout
+	os.Stdout = write
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-o", "-", "-outname=TriangleStdout"}
+	main()
+	os.Stdout = stdout
+	write.Close()
+	got, err := png.Decode(bytes.NewReader(<-sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameTestImage(t, got, decodeTestPNG(t, "testResources/TriangleSSVanilla.png"))
+	if _, err := os.Stat("GenerationDirectory/TriangleStdout"); err == nil {
+		t.Errorf("Nothing else was written, so there shouldn't be a folder")
+	}
+}
+
+func TestSheetReference(t *testing.T) {
+	fmt.Printf("TestSheetReference\n")
+	metadataDir, err := filepath.Abs("GenerationDirectory/Triangle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"GenerationDirectory/Triangle/sheet.png": "sheet.png",
+		"out/sheet.png":                          "../../out/sheet.png",
+		"-":                                      "",
+	}
+	for sheetOut, want := range cases {
+		if got := sheetReference(metadataDir, sheetOut); got != want {
+			t.Errorf("-o %v gave the metadata %q, want %q", sheetOut, got, want)
+		}
+	}
+}
+
+func TestOutDir(t *testing.T) {
+	fmt.Printf("TestOutDir\n")
+	os.RemoveAll("GenerationDirectory/Nested")
+	resetFlags()
+	os.Args = []string{"cmd", "-template=triangle", "-outdir=GenerationDirectory/Nested/Deeper", "-individuals", "-namepattern={template}_{index:03}_{bits}.png"}
+	main()
+	sameTestImage(t, decodeTestPNG(t, "GenerationDirectory/Nested/Deeper/triangleSpriteSheet.png"), decodeTestPNG(t, "testResources/TriangleSSVanilla.png"))
+	sameTestImage(t, decodeTestPNG(t, "GenerationDirectory/Nested/Deeper/Individuals/triangle_127_01111111.png"), decodeTestPNG(t, "testResources/127Test.png"))
+}
+
+func TestTemplatePath(t *testing.T) {
+	fmt.Printf("TestTemplatePath\n")
+	resetFlags()
+	path, err := filepath.Abs("Templates/Triangle.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Args = []string{"cmd", "-template=" + path}
+	main()
+	//a template given as a path is named after its file
+	sameTestImage(t, decodeTestPNG(t, "GenerationDirectory/Triangle/TriangleSpriteSheet.png"), decodeTestPNG(t, "testResources/TriangleSSVanilla.png"))
+}
+
+func TestCheckChoice(t *testing.T) {
+	fmt.Printf("TestCheckChoice\n")
+	if err := checkChoice("islands", "Report", "discard", "report"); err != nil {
+		t.Errorf("Choices shouldn't care about case, got %v", err)
+	}
+	if err := checkChoice("connected", "6", "0", "4", "8"); err == nil || !strings.Contains(err.Error(), "0, 4, 8") {
+		t.Errorf("Unknown values should fail and list the choices, got %v", err)
+	}
+}
+
+//Checks two images have the same colors, whatever kind of image they are.
+func sameTestImage(t *testing.T, got, want image.Image) {
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("Got a %v image, wanted %v", got.Bounds(), want.Bounds())
+	}
+	for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+		for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+			r1, g1, b1, a1 := want.At(x, y).RGBA()
+			r2, g2, b2, a2 := got.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("Wanted color %v at point %v,%v; got color %v", want.At(x, y), x, y, got.At(x, y))
+			}
+		}
+	}
+}
+
 //This also tests the reading of red template pixels (outlines), which I forgot to consider.  We'll
 //use the example face.png template to have that included.  Do this test last otherwise you need to reset
 //all the flags set here.
@@ -217,7 +730,7 @@ func TestInputSanitizers(t *testing.T) {
 	fmt.Printf("TestInputSanitizers\n")
 	gotFileName := "/GenerationDirectory/BadInput/BadInputSpriteSheet.png"
 	wantFileName := "/testResources/faceSSbadinput.png"
-	testArgs := []string{"cmd", "-template=face", "-legacy=f", "-color=badInput", "-accent=BadInput", "-fill=BadInput", "-sheetwidth=1000", "-upscale=0", "-outname=BadInput", "-fold=none"}
+	testArgs := []string{"cmd", "-template=face", "-legacy=f", "-color=badInput", "-accent=BadInput", "-fill=BadInput", "-sheetwidth=-1", "-upscale=0", "-outname=BadInput", "-fold=none"}
 	Compare(t, gotFileName, wantFileName, testArgs)
 }
 
@@ -256,7 +769,30 @@ func BenchmarkLegacy(b *testing.B) {
 	}
 }
 
+//Sets our flags back to their defaults, leaving the testing package's own flags alone.
+func resetFlags() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+}
+
+func readTestMetadata(t *testing.T, fileName string) sheetMetadata {
+	metadataFile, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metadataFile.Close()
+	var metadata sheetMetadata
+	if err := json.NewDecoder(metadataFile).Decode(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
 func Compare(t *testing.T, gotFileName, wantFileName string, testArgs []string) {
+	resetFlags()
 	os.Args = testArgs
 
 	main()
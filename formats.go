@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+//An imageFormat is a lossless format we can write our images in.  Only pngs can be indexed, so the other encoders
+//ignore the palette.
+type imageFormat struct {
+	extension string
+	encode    func(w io.Writer, img *image.RGBA, palette color.Palette) error
+}
+
+//The formats -format accepts.
+var imageFormats = map[string]imageFormat{
+	"png":           {".png", encodePNG},
+	"qoi":           {".qoi", encodeQOI},
+	"webp-lossless": {".webp", encodeWebP},
+	"bmp":           {".bmp", encodeBMP},
+	"tga":           {".tga", encodeTGA},
+}
+
+//Looks up a format by name, with an empty name meaning png.
+func lookupFormat(name string) (imageFormat, error) {
+	if name == "" {
+		name = "png"
+	}
+	format, ok := imageFormats[strings.ToLower(name)]
+	if !ok {
+		return format, errors.New("unknown format " + name + ", use png, qoi, webp-lossless, bmp or tga")
+	}
+	return format, nil
+}
+
+//Writes an image out in the given format.  With a palette, we write an indexed png instead of a 32-bit one.
+func writeImage(out *output, path string, img *image.RGBA, palette color.Palette, format imageFormat) {
+	out.write(path, func(w io.Writer) error {
+		return format.encode(w, img, palette)
+	})
+}
+
+//Other formats don't have indexed forms, so only pngs use the palette.
+func encodePNG(w io.Writer, img *image.RGBA, palette color.Palette) error {
+	if palette != nil {
+		paletted, err := toPaletted(img, palette)
+		if err != nil {
+			return err
+		}
+		return png.Encode(w, paletted)
+	}
+	return png.Encode(w, img)
+}
+
+//Walks an image's pixels left to right, top to bottom, without premultiplied alpha, since none of our other
+//formats expect it.
+func eachPixel(img *image.RGBA, do func(c color.NRGBA)) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			do(color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA))
+		}
+	}
+}
+
+//QOI (the Quite OK Image format) is a simple lossless format that encodes each pixel as a run of the last pixel,
+//a recently seen color, a small difference from the last pixel, or the color itself.
+func encodeQOI(w io.Writer, img *image.RGBA, palette color.Palette) error {
+	bounds := img.Bounds()
+	header := []byte{'q', 'o', 'i', 'f', 0, 0, 0, 0, 0, 0, 0, 0, 4, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[8:], uint32(bounds.Dy()))
+	out := header
+	var seen [64]color.NRGBA
+	last := color.NRGBA{0, 0, 0, 255}
+	run := 0
+	pixels := bounds.Dx() * bounds.Dy()
+	n := 0
+	eachPixel(img, func(c color.NRGBA) {
+		n++
+		if c == last {
+			run++
+			if run == 62 || n == pixels {
+				out = append(out, 0xc0|byte(run-1))
+				run = 0
+			}
+			return
+		}
+		if run > 0 {
+			out = append(out, 0xc0|byte(run-1))
+			run = 0
+		}
+		hash := (int(c.R)*3 + int(c.G)*5 + int(c.B)*7 + int(c.A)*11) % 64
+		if seen[hash] == c {
+			out = append(out, byte(hash))
+		} else {
+			seen[hash] = c
+			dr, dg, db := int8(c.R-last.R), int8(c.G-last.G), int8(c.B-last.B)
+			drg, dbg := dr-dg, db-dg
+			switch {
+			case c.A != last.A:
+				out = append(out, 0xff, c.R, c.G, c.B, c.A)
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				out = append(out, 0x40|byte(dr+2)<<4|byte(dg+2)<<2|byte(db+2))
+			case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+				out = append(out, 0x80|byte(dg+32), byte(drg+8)<<4|byte(dbg+8))
+			default:
+				out = append(out, 0xfe, c.R, c.G, c.B)
+			}
+		}
+		last = c
+	})
+	out = append(out, 0, 0, 0, 0, 0, 0, 0, 1)
+	_, err := w.Write(out)
+	return err
+}
+
+//Writes a 32-bit bmp.  The V4 header's bit masks tell readers where the alpha channel is, which plain bmps lack.
+func encodeBMP(w io.Writer, img *image.RGBA, palette color.Palette) error {
+	bounds := img.Bounds()
+	const headerSize = 14 + 108
+	dataSize := bounds.Dx() * bounds.Dy() * 4
+	header := make([]byte, headerSize)
+	le := binary.LittleEndian
+	copy(header, "BM")
+	le.PutUint32(header[2:], uint32(headerSize+dataSize))
+	le.PutUint32(header[10:], headerSize)
+	le.PutUint32(header[14:], 108)
+	le.PutUint32(header[18:], uint32(bounds.Dx()))
+	//a negative height stores rows top to bottom
+	le.PutUint32(header[22:], uint32(-int32(bounds.Dy())))
+	le.PutUint16(header[26:], 1)
+	le.PutUint16(header[28:], 32)
+	le.PutUint32(header[30:], 3) //bitfields
+	le.PutUint32(header[34:], uint32(dataSize))
+	le.PutUint32(header[38:], 2835) //72 dpi
+	le.PutUint32(header[42:], 2835)
+	le.PutUint32(header[54:], 0x00ff0000)
+	le.PutUint32(header[58:], 0x0000ff00)
+	le.PutUint32(header[62:], 0x000000ff)
+	le.PutUint32(header[66:], 0xff000000)
+	copy(header[70:], "BGRs") //sRGB, stored backwards
+	out := header
+	eachPixel(img, func(c color.NRGBA) {
+		out = append(out, c.B, c.G, c.R, c.A)
+	})
+	_, err := w.Write(out)
+	return err
+}
+
+//Writes a run length encoded 32-bit tga, which suits sprites and their flat colors well.
+func encodeTGA(w io.Writer, img *image.RGBA, palette color.Palette) error {
+	bounds := img.Bounds()
+	if bounds.Dx() > 65535 || bounds.Dy() > 65535 {
+		return errors.New("tga images can't be over 65535 pixels wide or tall")
+	}
+	header := make([]byte, 18)
+	header[2] = 10 //run length encoded true color
+	binary.LittleEndian.PutUint16(header[12:], uint16(bounds.Dx()))
+	binary.LittleEndian.PutUint16(header[14:], uint16(bounds.Dy()))
+	header[16] = 32
+	header[17] = 0x28 //8 bits of alpha, rows stored top to bottom
+	out := header
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var row []color.NRGBA
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			row = append(row, color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA))
+		}
+		//packets hold up to 128 pixels, either one pixel repeated, or pixels as they are.  They don't cross rows.
+		for x := 0; x < len(row); {
+			run := 1
+			for x+run < len(row) && run < 128 && row[x+run] == row[x] {
+				run++
+			}
+			if run > 1 {
+				out = append(out, 0x80|byte(run-1), row[x].B, row[x].G, row[x].R, row[x].A)
+				x += run
+				continue
+			}
+			raw := 1
+			for x+raw < len(row) && raw < 128 && (x+raw+1 >= len(row) || row[x+raw] != row[x+raw+1]) {
+				raw++
+			}
+			out = append(out, byte(raw-1))
+			for _, c := range row[x : x+raw] {
+				out = append(out, c.B, c.G, c.R, c.A)
+			}
+			x += raw
+		}
+	}
+	out = append(out, make([]byte, 8)...)
+	out = append(out, "TRUEVISION-XFILE.\x00"...)
+	_, err := w.Write(out)
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+//Reads back a qoi image, following the spec.
+func decodeTestQOI(t *testing.T, data []byte) *image.NRGBA {
+	if string(data[:4]) != "qoif" {
+		t.Fatalf("Missing the qoi magic bytes")
+	}
+	width, height := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var seen [64]color.NRGBA
+	c := color.NRGBA{0, 0, 0, 255}
+	p := 14
+	for n := 0; n < width*height; {
+		op := data[p]
+		p++
+		run := 1
+		switch {
+		case op == 0xfe:
+			c.R, c.G, c.B = data[p], data[p+1], data[p+2]
+			p += 3
+		case op == 0xff:
+			c = color.NRGBA{data[p], data[p+1], data[p+2], data[p+3]}
+			p += 4
+		case op>>6 == 0:
+			c = seen[op]
+		case op>>6 == 1:
+			c.R += op>>4&3 - 2
+			c.G += op>>2&3 - 2
+			c.B += op&3 - 2
+		case op>>6 == 2:
+			dg := op&0x3f - 32
+			c.R += dg + data[p]>>4 - 8
+			c.G += dg
+			c.B += dg + data[p]&0xf - 8
+			p++
+		default:
+			run = int(op&0x3f) + 1
+		}
+		seen[(int(c.R)*3+int(c.G)*5+int(c.B)*7+int(c.A)*11)%64] = c
+		for ; run > 0; run-- {
+			img.SetNRGBA(n%width, n/width, c)
+			n++
+		}
+	}
+	if !bytes.Equal(data[p:], []byte{0, 0, 0, 0, 0, 0, 0, 1}) {
+		t.Errorf("The qoi should end with its end marker")
+	}
+	return img
+}
+
+//Reads back a run length encoded, top to bottom tga.
+func decodeTestTGA(t *testing.T, data []byte) *image.NRGBA {
+	if data[2] != 10 || data[16] != 32 || data[17] != 0x28 {
+		t.Fatalf("Unexpected tga header %v", data[:18])
+	}
+	width, height := int(binary.LittleEndian.Uint16(data[12:])), int(binary.LittleEndian.Uint16(data[14:]))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	p := 18
+	for n := 0; n < width*height; {
+		packet := data[p]
+		p++
+		count := int(packet&0x7f) + 1
+		for j := 0; j < count; j++ {
+			img.SetNRGBA(n%width, n/width, color.NRGBA{data[p+2], data[p+1], data[p], data[p+3]})
+			n++
+			if packet&0x80 == 0 || j == count-1 {
+				p += 4
+			}
+		}
+	}
+	if string(data[len(data)-18:]) != "TRUEVISION-XFILE.\x00" {
+		t.Errorf("The tga should end with its footer")
+	}
+	return img
+}
+
+func TestFormats(t *testing.T) {
+	fmt.Printf("TestFormats\n")
+	//a sprite-ish image: runs of a few colors, some transparent, with the odd stray color
+	random := rand.New(rand.NewSource(7))
+	img := image.NewRGBA(image.Rect(0, 0, 150, 9))
+	colors := []color.RGBA{{}, {255, 0, 0, 255}, {250, 2, 3, 255}, {20, 90, 200, 255}}
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 150; x++ {
+			c := colors[(x/7+y)%len(colors)]
+			if random.Intn(10) == 0 {
+				c = color.RGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	same := func(name string, got *image.NRGBA) {
+		if got.Bounds() != img.Bounds() {
+			t.Fatalf("%v: got a %v image, want %v", name, got.Bounds(), img.Bounds())
+		}
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 150; x++ {
+				if color.NRGBAModel.Convert(img.RGBAAt(x, y)) != got.NRGBAAt(x, y) {
+					t.Fatalf("%v: wrong color %v at %v,%v", name, got.NRGBAAt(x, y), x, y)
+				}
+			}
+		}
+	}
+	encode := func(name string) []byte {
+		format, err := lookupFormat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := format.encode(&out, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	same("qoi", decodeTestQOI(t, encode("QOI")))
+	same("tga", decodeTestTGA(t, encode("tga")))
+
+	bmp := encode("bmp")
+	if string(bmp[:2]) != "BM" || int(binary.LittleEndian.Uint32(bmp[2:])) != len(bmp) || len(bmp) != 122+150*9*4 {
+		t.Errorf("Unexpected bmp header %v", bmp[:30])
+	}
+	webp := encode("webp-lossless")
+	if string(webp[:4]) != "RIFF" || string(webp[8:16]) != "WEBPVP8L" || int(binary.LittleEndian.Uint32(webp[4:]))+8 != len(webp) || webp[20] != 0x2f {
+		t.Errorf("Unexpected webp header %v", webp[:21])
+	}
+	if _, err := lookupFormat("jpeg"); err == nil {
+		t.Errorf("Unknown formats should fail")
+	}
+}
+
+func TestPrefixValue(t *testing.T) {
+	fmt.Printf("TestPrefixValue\n")
+	//decode each value the way a webp reader does, and make sure we get it back
+	for value := 1; value <= 4096; value++ {
+		symbol, extra, extraBits := prefixValue(value)
+		got := symbol + 1
+		if symbol >= 4 {
+			bits := (symbol - 2) >> 1
+			if bits != extraBits || extra >= 1<<uint(bits) {
+				t.Fatalf("Value %v got %v extra bits, want %v", value, extraBits, bits)
+			}
+			got = (2+symbol&1)<<uint(bits) + extra + 1
+		}
+		if got != value {
+			t.Fatalf("Value %v came back as %v", value, got)
+		}
+	}
+}
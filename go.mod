@@ -3,5 +3,6 @@ module github.com/philotfarnsworth/bitsprite
 go 1.16
 
 require (
+	github.com/lucasb-eyer/go-colorful v1.0.3
 	github.com/muesli/gamut v0.2.0
 )
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+//Builds count colors running through each of our stops in turn, like a gradient laid across the sprite sheet.
+//The stops can be blended in rgb, hsl, lab or oklab space, and easing bends how quickly we move between them.
+//Like gamut.Blends, we stop just short of either end, so two stops in lab space match our old blends exactly.
+func gradient(stops []color.Color, count int, space string, easing string) []color.Color {
+	var cc []color.Color
+	dl := 1.0 / float64(count+1)
+	for i := 0; i < count; i++ {
+		t := ease(dl*float64(i+1), easing)
+		//find which pair of stops we're between
+		scaled := t * float64(len(stops)-1)
+		k := int(scaled)
+		if k >= len(stops)-1 {
+			k = len(stops) - 2
+		}
+		c1, _ := colorful.MakeColor(stops[k])
+		c2, _ := colorful.MakeColor(stops[k+1])
+		cc = append(cc, blend(c1, c2, scaled-float64(k), space).Clamped())
+	}
+	return cc
+}
+
+//Reshapes our position along the gradient.  In starts slow, out ends slow, and inout does both.
+func ease(t float64, easing string) float64 {
+	switch strings.ToLower(easing) {
+	case "in":
+		return t * t
+	case "out":
+		return 1 - (1-t)*(1-t)
+	case "inout":
+		return t * t * (3 - 2*t)
+	}
+	return t
+}
+
+//Blends two colors in the color space provided, defaulting to lab.
+func blend(c1, c2 colorful.Color, t float64, space string) colorful.Color {
+	switch strings.ToLower(space) {
+	case "rgb":
+		return c1.BlendRgb(c2, t)
+	case "hsl":
+		h1, s1, l1 := c1.Hsl()
+		h2, s2, l2 := c2.Hsl()
+		//take the short way around the color wheel
+		if h2-h1 > 180 {
+			h1 += 360
+		} else if h1-h2 > 180 {
+			h2 += 360
+		}
+		return colorful.Hsl(math.Mod(h1+t*(h2-h1), 360), s1+t*(s2-s1), l1+t*(l2-l1))
+	case "oklab":
+		l1, a1, b1 := toOklab(c1)
+		l2, a2, b2 := toOklab(c2)
+		return fromOklab(l1+t*(l2-l1), a1+t*(a2-a1), b1+t*(b2-b1))
+	}
+	return c1.BlendLab(c2, t)
+}
+
+//Converts a color to Björn Ottosson's oklab, which blends more evenly than lab, especially through blues.
+func toOklab(c colorful.Color) (l, a, b float64) {
+	r, g, bl := c.LinearRgb()
+	lc := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*bl)
+	mc := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*bl)
+	sc := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*bl)
+	l = 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc
+	a = 1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc
+	b = 0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc
+	return l, a, b
+}
+
+//Converts oklab back to a color.
+func fromOklab(l, a, b float64) colorful.Color {
+	lc := l + 0.3963377774*a + 0.2158037573*b
+	mc := l - 0.1055613458*a - 0.0638541728*b
+	sc := l - 0.0894841775*a - 1.2914855480*b
+	lc, mc, sc = lc*lc*lc, mc*mc*mc, sc*sc*sc
+	return colorful.LinearRgb(
+		4.0767416621*lc-3.3077115913*mc+0.2309699292*sc,
+		-1.2684380046*lc+2.6097574011*mc-0.3413193965*sc,
+		-0.0041960863*lc-0.7034186147*mc+1.7076147010*sc)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"testing"
+
+	"github.com/muesli/gamut"
+)
+
+func TestGradientStops(t *testing.T) {
+	fmt.Printf("TestGradientStops\n")
+	//two stops in lab space should match gamut's blends, which we used before gradients
+	first, last := gamut.Hex("#ff0000"), gamut.Hex("#0000ff")
+	want := gamut.Blends(first, last, 256)
+	got := gradient([]color.Color{first, last}, 256, "lab", "linear")
+	for j := range want {
+		if hexColor(got[j]) != hexColor(want[j]) {
+			t.Fatalf("Color %v: got %v, want %v", j, hexColor(got[j]), hexColor(want[j]))
+		}
+	}
+
+	//with three stops, the middle of the sheet should land on the middle color
+	for _, space := range []string{"rgb", "hsl", "lab", "oklab"} {
+		got = gradient([]color.Color{first, gamut.Hex("#00ff00"), last}, 255, space, "linear")
+		if hexColor(got[127]) != "#00ff00" {
+			t.Errorf("%v: middle color is %v, want #00ff00", space, hexColor(got[127]))
+		}
+	}
+}
+
+func TestEasing(t *testing.T) {
+	fmt.Printf("TestEasing\n")
+	for _, easing := range []string{"linear", "in", "out", "inout"} {
+		if ease(0, easing) != 0 || ease(1, easing) != 1 {
+			t.Errorf("%v easing doesn't keep its ends", easing)
+		}
+	}
+	if ease(.25, "in") >= .25 || ease(.25, "out") <= .25 || ease(.25, "inout") >= .25 || ease(.75, "inout") <= .75 {
+		t.Errorf("easing curves bend the wrong way")
+	}
+}
+
+func hexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//A namePattern names individual sprite files from a pattern like {template}_{index:03}_{bits}, filling in:
+//	{template} the name of the template
+//	{index} the variant's number, which can be zero padded to a width, like {index:03}
+//	{bits} the number each segment was resolved with, as 8 binary digits, joined with -
+//Anything outside of braces is kept as is, so patterns can also sort sprites into folders.
+type namePattern []namePart
+
+//A piece of a namePattern, either plain text or a placeholder.
+type namePart struct {
+	text, field string
+	width       int
+}
+
+//Breaks a name pattern into its parts.  File extensions come from -format, so one at the end of the pattern is
+//dropped.  Patterns can sort sprites into folders, but only below the individuals folder, so absolute paths and ..
+//are turned down.
+func parseNamePattern(pattern string) (namePattern, error) {
+	if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "/") || strings.HasPrefix(pattern, "\\") || filepath.VolumeName(pattern) != "" {
+		return nil, errors.New("name pattern " + pattern + " is an absolute path, patterns are relative to the individuals folder")
+	}
+	for _, folder := range strings.FieldsFunc(pattern, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if folder == ".." {
+			return nil, errors.New("name pattern " + pattern + " uses .., patterns can't leave the individuals folder")
+		}
+	}
+	if ext := filepath.Ext(pattern); ext != "" && !strings.ContainsAny(ext, "{}") {
+		pattern = strings.TrimSuffix(pattern, ext)
+	}
+	var parts namePattern
+	whole := pattern
+	for pattern != "" {
+		open := strings.Index(pattern, "{")
+		if open == -1 {
+			parts = append(parts, namePart{text: pattern})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, namePart{text: pattern[:open]})
+		}
+		end := strings.Index(pattern[open:], "}")
+		if end == -1 {
+			return nil, errors.New("unclosed { in name pattern " + pattern)
+		}
+		end += open
+		part := namePart{field: pattern[open+1 : end]}
+		if colon := strings.Index(part.field, ":"); colon != -1 {
+			width, err := strconv.Atoi(part.field[colon+1:])
+			if err != nil || width < 0 {
+				return nil, fmt.Errorf("bad width in name pattern placeholder {%v}", part.field)
+			}
+			part.field, part.width = part.field[:colon], width
+		}
+		if part.field != "template" && part.field != "index" && part.field != "bits" {
+			return nil, fmt.Errorf("unknown name pattern placeholder {%v}, use {template}, {index} or {bits}", part.field)
+		}
+		parts = append(parts, part)
+		pattern = pattern[end+1:]
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty name pattern")
+	}
+	//without something that tells variants apart, every sprite would get the same name and overwrite the last
+	for _, part := range parts {
+		if part.field == "index" || part.field == "bits" {
+			return parts, nil
+		}
+	}
+	return nil, errors.New("name pattern " + whole + " needs {index} or {bits}, or every sprite gets the same name")
+}
+
+//Names a variant's file, without its extension.
+func (p namePattern) name(templateName string, v variant) string {
+	var name strings.Builder
+	for _, part := range p {
+		switch part.field {
+		case "template":
+			name.WriteString(templateName)
+		case "index":
+			fmt.Fprintf(&name, "%0*d", part.width, v.index)
+		case "bits":
+			var bits []string
+			for _, n := range v.numbers {
+				bits = append(bits, fmt.Sprintf("%08b", n&0xff))
+			}
+			name.WriteString(strings.Join(bits, "-"))
+		default:
+			name.WriteString(part.text)
+		}
+	}
+	return name.String()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNamePattern(t *testing.T) {
+	fmt.Printf("TestNamePattern\n")
+	v := variant{index: 7, numbers: []int{5, 200}}
+	cases := map[string]string{
+		"{index}":                          "7",
+		"{template}_{index:03}_{bits}.png": "Flower_007_00000101-11001000",
+		"sprites/{index:2}":                "sprites/07",
+		"a}{index}":                        "a}7",
+		"..{index}":                        "..7",
+	}
+	for pattern, want := range cases {
+		namer, err := parseNamePattern(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := namer.name("Flower", v); got != want {
+			t.Errorf("%v named the sprite %v, want %v", pattern, got, want)
+		}
+	}
+	for _, pattern := range []string{"{color}", "{index:x}", "{index", "", "../{index}", "sprites/../../{index}", "/tmp/{index}", "..\\{index}", "{template}", "sprites/fixed"} {
+		if _, err := parseNamePattern(pattern); err == nil {
+			t.Errorf("%q should fail", pattern)
+		}
+	}
+}
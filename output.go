@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//An output is where our files end up.  Normally that's straight to disk, under root, but with an archive everything
+//is bundled into a single zip or tar file next to root instead, which beats creating hundreds of small files on a
+//network drive.  The path - is stdout, for use in pipelines.
+type output struct {
+	root string
+	file *os.File
+	zip  *zip.Writer
+	tar  *tar.Writer
+}
+
+//Opens an output for files under root, archiving them in root.zip or root.tar if asked.
+func newOutput(root, archive string) (*output, error) {
+	o := &output{root: root}
+	archive = strings.ToLower(archive)
+	if archive == "" {
+		return o, nil
+	}
+	if archive != "zip" && archive != "tar" {
+		return nil, errors.New("unknown archive " + archive + ", use zip or tar")
+	}
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(root + "." + archive)
+	if err != nil {
+		return nil, err
+	}
+	o.file = file
+	if archive == "zip" {
+		o.zip = zip.NewWriter(file)
+	} else {
+		o.tar = tar.NewWriter(file)
+	}
+	return o, nil
+}
+
+//Writes a file, with encode filling in its contents.  Folders on disk are only created once something goes in them,
+//along with any folders above them that are missing.
+func (o *output) write(path string, encode func(w io.Writer) error) {
+	switch {
+	case path == "-":
+		out := bufio.NewWriter(os.Stdout)
+		check(encode(out))
+		check(out.Flush())
+	case o.zip != nil:
+		w, err := o.zip.CreateHeader(&zip.FileHeader{Name: o.name(path), Method: zip.Deflate, Modified: time.Now()})
+		check(err)
+		check(encode(w))
+	case o.tar != nil:
+		//tar headers need the size of the file up front
+		var contents bytes.Buffer
+		check(encode(&contents))
+		check(o.tar.WriteHeader(&tar.Header{Name: o.name(path), Mode: 0644, Size: int64(contents.Len()), ModTime: time.Now()}))
+		_, err := o.tar.Write(contents.Bytes())
+		check(err)
+	default:
+		check(os.MkdirAll(filepath.Dir(path), 0755))
+		file, err := os.Create(path)
+		check(err)
+		defer file.Close()
+		out := bufio.NewWriter(file)
+		check(encode(out))
+		check(out.Flush())
+	}
+}
+
+//Paths in an archive start with root's name, so unpacking one gives the same folder we'd have written.
+func (o *output) name(path string) string {
+	name, err := filepath.Rel(filepath.Dir(o.root), path)
+	check(err)
+	return filepath.ToSlash(name)
+}
+
+//Works out how the metadata, written to metadataDir, should point at a sheet written elsewhere with -o.  The path
+//is relative to the metadata, so the two can be moved together, unless they're on different drives.  A sheet
+//written to stdout has no path to point at.
+func sheetReference(metadataDir, sheetOut string) string {
+	if sheetOut == "-" {
+		return ""
+	}
+	sheet, err := filepath.Abs(sheetOut)
+	check(err)
+	if rel, err := filepath.Rel(metadataDir, sheet); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(sheet)
+}
+
+//Finishes off the archive, if there is one.
+func (o *output) close() {
+	if o.zip != nil {
+		check(o.zip.Close())
+	}
+	if o.tar != nil {
+		check(o.tar.Close())
+	}
+	if o.file != nil {
+		check(o.file.Close())
+	}
+}
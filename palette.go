@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+//Reads a palette file, guessing the format from the extension.  We understand GIMP (.gpl), plain hex lists (.hex),
+//Paint.NET (.txt), JASC (.pal) and Adobe Swatch Exchange (.ase), which covers everything Lospec hands out besides images.
+func readPalette(path string) ([]color.RGBA, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var palette []color.RGBA
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpl":
+		palette, err = parseGPL(data)
+	case ".hex":
+		palette, err = parseHexList(data, false)
+	case ".txt":
+		palette, err = parseHexList(data, true)
+	case ".pal":
+		palette, err = parseJASC(data)
+	case ".ase":
+		palette, err = parseASE(data)
+	default:
+		return nil, fmt.Errorf("unknown palette format %q, use .gpl, .hex, .txt, .pal or .ase", filepath.Ext(path))
+	}
+	if err == nil && len(palette) == 0 {
+		err = errors.New("palette " + path + " has no colors")
+	}
+	return palette, err
+}
+
+//GIMP palettes start with a header, then list colors as "R G B name", with # marking comments.
+func parseGPL(data []byte) ([]color.RGBA, error) {
+	var palette []color.RGBA
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "GIMP") || strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+		c, err := parseRGBFields(strings.Fields(line))
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	return palette, scanner.Err()
+}
+
+//Hex lists hold one RRGGBB per line.  Paint.NET uses AARRGGBB instead, with ; marking comments.  Our colors are
+//all opaque, so a translucent entry is an error rather than quietly losing its alpha.
+func parseHexList(data []byte, alpha bool) ([]color.RGBA, error) {
+	var palette []color.RGBA
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "#")
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		value, err := strconv.ParseUint(line, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad palette color %q", line)
+		}
+		c := color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 255}
+		if alpha && len(line) == 8 && uint8(value>>24) != 255 {
+			return nil, fmt.Errorf("palette color %q isn't opaque, only FF alpha is supported", line)
+		}
+		palette = append(palette, c)
+	}
+	return palette, scanner.Err()
+}
+
+//JASC palettes start with JASC-PAL, a version and a color count, then list colors as "R G B".
+func parseJASC(data []byte) ([]color.RGBA, error) {
+	var palette []color.RGBA
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 0; scanner.Scan(); line++ {
+		if line < 3 || strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		c, err := parseRGBFields(strings.Fields(scanner.Text()))
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	return palette, scanner.Err()
+}
+
+func parseRGBFields(fields []string) (color.RGBA, error) {
+	if len(fields) < 3 {
+		return color.RGBA{}, fmt.Errorf("bad palette line %q", strings.Join(fields, " "))
+	}
+	var rgb [3]uint8
+	for j := range rgb {
+		value, err := strconv.Atoi(fields[j])
+		if err != nil || value < 0 || value > 255 {
+			return color.RGBA{}, fmt.Errorf("bad palette line %q", strings.Join(fields, " "))
+		}
+		rgb[j] = uint8(value)
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}, nil
+}
+
+//Adobe Swatch Exchange files are big endian blocks.  We only care about the color entries, and skip groups.
+func parseASE(data []byte) ([]color.RGBA, error) {
+	reader := bytes.NewReader(data)
+	var header struct {
+		Signature [4]byte
+		Major     uint16
+		Minor     uint16
+		Blocks    uint32
+	}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil || string(header.Signature[:]) != "ASEF" {
+		return nil, errors.New("not an Adobe Swatch Exchange file")
+	}
+	var palette []color.RGBA
+	for b := uint32(0); b < header.Blocks; b++ {
+		var blockType uint16
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &blockType); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(reader, block); err != nil {
+			return nil, err
+		}
+		if blockType != 0x0001 {
+			continue
+		}
+		c, err := parseASEColor(block)
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	return palette, nil
+}
+
+//A color entry holds a UTF-16 name, a color model, then the model's values as floats.
+func parseASEColor(block []byte) (color.RGBA, error) {
+	reader := bytes.NewReader(block)
+	var nameLength uint16
+	if err := binary.Read(reader, binary.BigEndian, &nameLength); err != nil {
+		return color.RGBA{}, err
+	}
+	name := make([]uint16, nameLength)
+	if err := binary.Read(reader, binary.BigEndian, &name); err != nil {
+		return color.RGBA{}, err
+	}
+	var model [4]byte
+	if err := binary.Read(reader, binary.BigEndian, &model); err != nil {
+		return color.RGBA{}, err
+	}
+	values := map[string]int{"RGB ": 3, "LAB ": 3, "CMYK": 4, "Gray": 1}
+	count, ok := values[string(model[:])]
+	if !ok {
+		return color.RGBA{}, fmt.Errorf("unknown color model %q for swatch %q", model, string(utf16.Decode(name)))
+	}
+	v := make([]float32, count)
+	if err := binary.Read(reader, binary.BigEndian, &v); err != nil {
+		return color.RGBA{}, err
+	}
+	var c colorful.Color
+	switch string(model[:]) {
+	case "RGB ":
+		c = colorful.Color{R: float64(v[0]), G: float64(v[1]), B: float64(v[2])}
+	case "LAB ":
+		c = colorful.Lab(float64(v[0]), float64(v[1])/100, float64(v[2])/100)
+	case "CMYK":
+		k := 1 - float64(v[3])
+		c = colorful.Color{R: (1 - float64(v[0])) * k, G: (1 - float64(v[1])) * k, B: (1 - float64(v[2])) * k}
+	case "Gray":
+		c = colorful.Color{R: float64(v[0]), G: float64(v[0]), B: float64(v[0])}
+	}
+	r, g, b := c.Clamped().RGB255()
+	return color.RGBA{r, g, b, 255}, nil
+}
+
+//Works out which palette entries go to which pixels.  Roles are listed like "bit=3,accent=5,outline=0", with
+//entries counted from 0, and a role can blend between entries like "fill=1:4".  Bit, accent, fill and outline
+//colors that aren't listed are picked from the palette by lightness, darkest entry for the outlines, then
+//accent, bit and fill working up from there.  Background is left alone unless it's listed.  We hand back color
+//strings in the same form as our color flags.
+func paletteRoles(palette []color.RGBA, roles string) (map[Pixel]string, error) {
+	assigned := make(map[Pixel]string)
+	for _, role := range strings.Split(roles, ",") {
+		if strings.TrimSpace(role) == "" || strings.EqualFold(strings.TrimSpace(role), "auto") {
+			continue
+		}
+		pair := strings.SplitN(role, "=", 2)
+		pixel, ok := pixelNames[strings.ToLower(strings.TrimSpace(pair[0]))]
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("bad palette role %q, use role=index", role)
+		}
+		var hexes []string
+		for _, entry := range strings.Split(pair[1], ":") {
+			index, err := strconv.Atoi(strings.TrimSpace(entry))
+			if err != nil || index < 0 || index >= len(palette) {
+				return nil, fmt.Errorf("bad palette index %q for %v, the palette has %v colors", entry, pair[0], len(palette))
+			}
+			hexes = append(hexes, hexString(palette[index]))
+		}
+		assigned[pixel] = strings.Join(hexes, ":")
+	}
+
+	//Sort the palette by lightness for anything left over.
+	sorted := append([]color.RGBA(nil), palette...)
+	sort.SliceStable(sorted, func(a, b int) bool { return lightness(sorted[a]) < lightness(sorted[b]) })
+	for j, pixel := range []Pixel{Outline, Accent, Bit, Fill} {
+		if _, ok := assigned[pixel]; !ok {
+			assigned[pixel] = hexString(sorted[j*(len(sorted)-1)/3])
+		}
+	}
+	return assigned, nil
+}
+
+//Finds the palette entry closest to c, measured in Lab space.
+func nearestColor(c color.Color, palette []color.RGBA) color.RGBA {
+	target, _ := colorful.MakeColor(c)
+	best := palette[0]
+	bestDistance := math.Inf(1)
+	for _, entry := range palette {
+		candidate, _ := colorful.MakeColor(entry)
+		if d := target.DistanceLab(candidate); d < bestDistance {
+			best = entry
+			bestDistance = d
+		}
+	}
+	//keep transparency, which the palette may not know about
+	_, _, _, a := c.RGBA()
+	if a == 0 {
+		return color.RGBA{}
+	}
+	return best
+}
+
+func lightness(c color.RGBA) float64 {
+	l, _, _ := colorful.Color{R: float64(c.R) / 255, G: float64(c.G) / 255, B: float64(c.B) / 255}.Lab()
+	return l
+}
+
+func hexString(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestPaletteFormats(t *testing.T) {
+	fmt.Printf("TestPaletteFormats\n")
+	want := []color.RGBA{{20, 12, 28, 255}, {218, 212, 94, 255}}
+	dir := t.TempDir()
+	files := map[string]string{
+		"test.gpl": "GIMP Palette\nName: Test\nColumns: 2\n#\n 20  12  28\tNight\n218 212  94\tStraw\n",
+		"test.hex": "140c1c\ndad45e\n",
+		"test.txt": ";paint.net Palette File\n;Colors: 2\nFF140C1C\nFFDAD45E\n",
+		"test.pal": "JASC-PAL\n0100\n2\n20 12 28\n218 212 94\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		checkPalette(t, path, want)
+	}
+
+	//Adobe Swatch Exchange is binary, so we build one up by hand.
+	var ase bytes.Buffer
+	ase.WriteString("ASEF")
+	binary.Write(&ase, binary.BigEndian, []uint16{1, 0})
+	binary.Write(&ase, binary.BigEndian, uint32(len(want)))
+	for _, c := range want {
+		var block bytes.Buffer
+		name := utf16.Encode([]rune("swatch\x00"))
+		binary.Write(&block, binary.BigEndian, uint16(len(name)))
+		binary.Write(&block, binary.BigEndian, name)
+		block.WriteString("RGB ")
+		binary.Write(&block, binary.BigEndian, []float32{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255})
+		binary.Write(&block, binary.BigEndian, uint16(2))
+		binary.Write(&ase, binary.BigEndian, uint16(1))
+		binary.Write(&ase, binary.BigEndian, uint32(block.Len()))
+		ase.Write(block.Bytes())
+	}
+	path := filepath.Join(dir, "test.ase")
+	if err := os.WriteFile(path, ase.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkPalette(t, path, want)
+
+	//translucent Paint.NET entries can't be kept, so they're turned down
+	path = filepath.Join(dir, "translucent.txt")
+	if err := os.WriteFile(path, []byte(";paint.net Palette File\nFF140C1C\n80DAD45E\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPalette(path); err == nil {
+		t.Errorf("Translucent palette colors should fail")
+	}
+}
+
+func checkPalette(t *testing.T, path string, want []color.RGBA) {
+	got, err := readPalette(path)
+	if err != nil {
+		t.Fatalf("%v: %v", filepath.Base(path), err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%v: got %v colors, wanted %v", filepath.Base(path), len(got), len(want))
+	}
+	for j := range want {
+		if math.Abs(float64(got[j].R)-float64(want[j].R)) > 1 || math.Abs(float64(got[j].G)-float64(want[j].G)) > 1 || math.Abs(float64(got[j].B)-float64(want[j].B)) > 1 {
+			t.Fatalf("%v: got color %v, wanted %v", filepath.Base(path), got[j], want[j])
+		}
+	}
+}
+
+func TestPaletteRoles(t *testing.T) {
+	fmt.Printf("TestPaletteRoles\n")
+	palette, err := readPalette("testResources/Palette.gpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := paletteRoles(palette, "bit=6,fill=5:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Pixel]string{Bit: "#d04648", Fill: "#346524:#dad45e", Outline: "#140c1c", Accent: "#30346d"}
+	for pixel, hex := range want {
+		if roles[pixel] != hex {
+			t.Fatalf("Got %v for pixel %v, wanted %v", roles[pixel], pixel, hex)
+		}
+	}
+	if _, ok := roles[Background]; ok {
+		t.Fatalf("Background shouldn't be assigned unless asked")
+	}
+	if _, err := paletteRoles(palette, "bit=8"); err == nil {
+		t.Fatalf("Wanted an error for an index outside the palette")
+	}
+}
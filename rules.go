@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+)
+
+//bitRules change how we pick numbers when sampling variants.  Instead of every bit having an even chance of
+//being active, each bit (or group of bits) can be given its own odds, and numbers that break a constraint are
+//never drawn.  Bits are counted from 0, in the order they are read from the template.
+type bitRules struct {
+	Probability []float64  `json:"probability"` //chance each bit is active, bits not listed default to .5
+	Groups      []bitGroup `json:"groups"`
+	MinOn       int        `json:"minOn"`     //at least this many bits must be active
+	MaxOn       int        `json:"maxOn"`     //no more than this many bits may be active, 0 means no limit
+	Implies     [][2]int   `json:"implies"`   //[a, b] means when bit a is active, bit b must be too
+	Exclusive   [][]int    `json:"exclusive"` //no more than one bit of each list may be active
+}
+
+//A bitGroup sets the same odds for several bits at once.
+type bitGroup struct {
+	Bits        []int   `json:"bits"`
+	Probability float64 `json:"probability"`
+}
+
+//Counts the bit pixels of a template, which tells us how many bits of our number actually show up.  Anything
+//over 8 bits just repeats the pattern, so we cap it there.
+func countBits(pixels []Pixel) int {
+	count := 0
+	for _, pixel := range pixels {
+		if pixel == Bit {
+			count++
+		}
+	}
+	if count > 8 {
+		count = 8
+	}
+	return count
+}
+
+//Works out how likely each number is to be drawn for a template with the provided number of bits.  Numbers
+//that break a constraint get no weight at all.
+func (r *bitRules) weights(bitCount int) ([]float64, error) {
+	if err := r.validate(bitCount); err != nil {
+		return nil, err
+	}
+	odds := make([]float64, bitCount)
+	for b := range odds {
+		odds[b] = .5
+		if b < len(r.Probability) {
+			odds[b] = r.Probability[b]
+		}
+	}
+	for _, group := range r.Groups {
+		for _, b := range group.Bits {
+			odds[b] = group.Probability
+		}
+	}
+
+	weights := make([]float64, 1<<bitCount)
+	total := 0.0
+	for n := range weights {
+		if !r.allows(n) {
+			continue
+		}
+		weights[n] = 1
+		for b := 0; b < bitCount; b++ {
+			if (n>>b)&1 == 1 {
+				weights[n] *= odds[b]
+			} else {
+				weights[n] *= 1 - odds[b]
+			}
+		}
+		total += weights[n]
+	}
+	if total <= 0 {
+		return nil, errors.New("bit rules can't be satisfied by any variant")
+	}
+	return weights, nil
+}
+
+//Makes sure our rules make sense for a template with the provided number of bits.  Every bit a rule names has
+//to be one of the template's bits, and odds have to be between 0 and 1.
+func (r *bitRules) validate(bitCount int) error {
+	checkBit := func(rule string, b int) error {
+		if b < 0 || b >= bitCount {
+			return fmt.Errorf("%v names bit %v, but the template only has bits 0 to %v", rule, b, bitCount-1)
+		}
+		return nil
+	}
+	checkOdds := func(rule string, p float64) error {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%v has a probability of %v, probabilities go from 0 to 1", rule, p)
+		}
+		return nil
+	}
+	for b, p := range r.Probability {
+		if err := checkOdds(fmt.Sprintf("bit %v", b), p); err != nil {
+			return err
+		}
+	}
+	for _, group := range r.Groups {
+		if err := checkOdds("a group", group.Probability); err != nil {
+			return err
+		}
+		for _, b := range group.Bits {
+			if err := checkBit("a group", b); err != nil {
+				return err
+			}
+		}
+	}
+	for _, pair := range r.Implies {
+		for _, b := range pair {
+			if err := checkBit("implies", b); err != nil {
+				return err
+			}
+		}
+	}
+	for _, list := range r.Exclusive {
+		for _, b := range list {
+			if err := checkBit("exclusive", b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//Checks a number against our constraints.
+func (r *bitRules) allows(n int) bool {
+	on := bits.OnesCount(uint(n))
+	if on < r.MinOn || (r.MaxOn > 0 && on > r.MaxOn) {
+		return false
+	}
+	for _, pair := range r.Implies {
+		if (n>>pair[0])&1 == 1 && (n>>pair[1])&1 == 0 {
+			return false
+		}
+	}
+	for _, list := range r.Exclusive {
+		active := 0
+		for _, b := range list {
+			active += (n >> b) & 1
+		}
+		if active > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+//Draws count numbers for a template with the provided number of bits, following our rules.
+func (r *bitRules) sample(bitCount int, count int) ([]int, error) {
+	weights, err := r.weights(bitCount)
+	if err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	numbers := make([]int, count)
+	for i := range numbers {
+		pick := rand.Float64() * total
+		for n, w := range weights {
+			pick -= w
+			if pick < 0 || n == len(weights)-1 {
+				numbers[i] = n
+				break
+			}
+		}
+		//floating point can leave us on a number with no weight, so walk back to one that's allowed.
+		for weights[numbers[i]] == 0 {
+			numbers[i]--
+		}
+	}
+	return numbers, nil
+}
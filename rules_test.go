@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+func TestBitRules(t *testing.T) {
+	fmt.Printf("TestBitRules\n")
+	rules := bitRules{MinOn: 2, MaxOn: 5, Implies: [][2]int{{3, 4}}, Exclusive: [][]int{{1, 2}}}
+	rand.Seed(1)
+	numbers, err := rules.sample(8, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range numbers {
+		on := bits.OnesCount(uint(n))
+		if on < 2 || on > 5 {
+			t.Fatalf("Sampled %08b, which has %v bits on", n, on)
+		}
+		if n&(1<<3) != 0 && n&(1<<4) == 0 {
+			t.Fatalf("Sampled %08b, bit 3 is on without bit 4", n)
+		}
+		if n&(1<<1) != 0 && n&(1<<2) != 0 {
+			t.Fatalf("Sampled %08b, bits 1 and 2 are both on", n)
+		}
+	}
+}
+
+func TestBitOdds(t *testing.T) {
+	fmt.Printf("TestBitOdds\n")
+	rules := bitRules{Probability: []float64{1, 0}, Groups: []bitGroup{{Bits: []int{2, 3}, Probability: 1}}}
+	weights, err := rules.weights(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//bit 0 always on, bit 1 always off, bits 2 and 3 always on
+	for n, w := range weights {
+		if n == 13 && w != 1 {
+			t.Fatalf("Wanted 1101 to always be drawn, got weight %v", w)
+		} else if n != 13 && w != 0 {
+			t.Fatalf("Wanted %04b to never be drawn, got weight %v", n, w)
+		}
+	}
+}
+
+func TestImpossibleRules(t *testing.T) {
+	fmt.Printf("TestImpossibleRules\n")
+	rules := bitRules{MinOn: 5}
+	if _, err := rules.weights(4); err == nil {
+		t.Fatal("Wanted an error for rules no variant can satisfy")
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	fmt.Printf("TestBadRules\n")
+	bad := []bitRules{
+		{Implies: [][2]int{{-1, 2}}},
+		{Implies: [][2]int{{0, 4}}},
+		{Exclusive: [][]int{{1, 7}}},
+		{Groups: []bitGroup{{Bits: []int{5}, Probability: .5}}},
+		{Groups: []bitGroup{{Bits: []int{1}, Probability: 2}}},
+		{Probability: []float64{.5, -.1}},
+	}
+	for _, rules := range bad {
+		if _, err := rules.weights(4); err == nil {
+			t.Errorf("Wanted an error for %+v", rules)
+		}
+	}
+}
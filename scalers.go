@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+//Tells us how many times larger a scaler makes a sprite.  Nearest is our plain block scaling, and leaves the
+//scaling to -upscale.
+func scalerFactor(scaler string) (int, error) {
+	factors := map[string]int{"": 1, "nearest": 1, "scale2x": 2, "epx": 2, "scale3x": 3, "scale4x": 4, "eagle": 2, "hq2x": 2, "hq3x": 3, "xbr": 2}
+	factor, ok := factors[strings.ToLower(scaler)]
+	if !ok {
+		return 0, errors.New("unknown scaler " + scaler + ", use nearest, scale2x, scale3x, scale4x, eagle, hq2x, hq3x or xbr")
+	}
+	return factor, nil
+}
+
+//The blending scalers mix neighboring colors together, rather than copying a neighbor's color.
+func scalerBlends(scaler string) bool {
+	switch strings.ToLower(scaler) {
+	case "hq2x", "hq3x", "xbr":
+		return true
+	}
+	return false
+}
+
+//Scales a sprite with one of our pixel art scalers.  Unlike block scaling, these look at each pixel's neighbors
+//to round off diagonal steps, which keeps sprites crisp at larger sizes.
+func scaleSprite(sprite *image.RGBA, scaler string) *image.RGBA {
+	switch strings.ToLower(scaler) {
+	case "hq2x":
+		return hqx(sprite, 2)
+	case "hq3x":
+		return hqx(sprite, 3)
+	case "xbr":
+		return xbr(sprite)
+	}
+	return scaleSources(sprite, scaler).apply(sprite)
+}
+
+//Scales another image the same size as a sprite, like its role sprite, with the choices the scaler made for the
+//sprite itself, so both get rounded off in the same places.  Only works for scalers that copy pixels, since roles
+//can't be blended.
+func scaleAlong(sprite, other *image.RGBA, scaler string) *image.RGBA {
+	return scaleSources(sprite, scaler).apply(other)
+}
+
+//A sourceMap records which pixel of the original sprite each pixel of a scaled sprite copies its color from.
+type sourceMap struct {
+	bounds  image.Rectangle
+	sources []image.Point
+}
+
+//Starts a source map for a scaled sprite of the given size.
+func newSourceMap(width, height int) sourceMap {
+	return sourceMap{image.Rect(0, 0, width, height), make([]image.Point, width*height)}
+}
+
+//Sets where a pixel of the scaled sprite copies from.
+func (m sourceMap) set(x, y int, source image.Point) {
+	m.sources[y*m.bounds.Dx()+x] = source
+}
+
+//Draws the scaled version of a sprite, or of anything laid out just like it.
+func (m sourceMap) apply(sprite *image.RGBA) *image.RGBA {
+	scaled := image.NewRGBA(m.bounds)
+	for j, p := range m.sources {
+		scaled.SetRGBA(j%m.bounds.Dx(), j/m.bounds.Dx(), sprite.RGBAAt(p.X, p.Y))
+	}
+	return scaled
+}
+
+//Chains two source maps, where next was worked out on the sprite m scaled, giving a map straight back to the
+//original sprite.
+func (m sourceMap) then(next sourceMap) sourceMap {
+	chained := newSourceMap(next.bounds.Dx(), next.bounds.Dy())
+	for j, p := range next.sources {
+		chained.sources[j] = m.sources[p.Y*m.bounds.Dx()+p.X]
+	}
+	return chained
+}
+
+//Works out where each pixel of a scaled sprite comes from, for the scalers that copy pixels.  Nearest leaves each
+//pixel where it is.
+func scaleSources(sprite *image.RGBA, scaler string) sourceMap {
+	switch strings.ToLower(scaler) {
+	case "scale2x", "epx":
+		return scale2x(sprite)
+	case "scale3x":
+		return scale3x(sprite)
+	case "scale4x":
+		first := scale2x(sprite)
+		return first.then(scale2x(first.apply(sprite)))
+	case "eagle":
+		return eagle(sprite)
+	}
+	bounds := sprite.Bounds()
+	same := newSourceMap(bounds.Dx(), bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			same.set(x, y, image.Point{x, y})
+		}
+	}
+	return same
+}
+
+//Finds a pixel by its position, where pixels past the edge take the color of the nearest edge pixel.
+func clampedPoint(sprite *image.RGBA, x, y int) image.Point {
+	bounds := sprite.Bounds()
+	if x < 0 {
+		x = 0
+	} else if x >= bounds.Dx() {
+		x = bounds.Dx() - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= bounds.Dy() {
+		y = bounds.Dy() - 1
+	}
+	return image.Point{x, y}
+}
+
+//Grabs the 3x3 neighborhood around a pixel, read left to right, top to bottom, both where each neighbor is and
+//its color.
+func neighborhood(sprite *image.RGBA, x, y int) ([9]image.Point, [9]color.RGBA) {
+	var points [9]image.Point
+	var colors [9]color.RGBA
+	for j := range points {
+		points[j] = clampedPoint(sprite, x+j%3-1, y+j/3-1)
+		colors[j] = sprite.RGBAAt(points[j].X, points[j].Y)
+	}
+	return points, colors
+}
+
+//Scale2x (also known as EPX) turns each pixel into 2x2, taking a neighbor's color for a corner when the two
+//neighbors touching that corner match, and the other two don't.
+func scale2x(sprite *image.RGBA) sourceMap {
+	bounds := sprite.Bounds()
+	scaled := newSourceMap(bounds.Dx()*2, bounds.Dy()*2)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			points, n := neighborhood(sprite, x, y)
+			const up, left, p, right, down = 1, 3, 4, 5, 7
+			e := [4]int{p, p, p, p}
+			if n[up] != n[down] && n[left] != n[right] {
+				if n[left] == n[up] {
+					e[0] = up
+				}
+				if n[up] == n[right] {
+					e[1] = right
+				}
+				if n[left] == n[down] {
+					e[2] = left
+				}
+				if n[down] == n[right] {
+					e[3] = down
+				}
+			}
+			for j, source := range e {
+				scaled.set(x*2+j%2, y*2+j/2, points[source])
+			}
+		}
+	}
+	return scaled
+}
+
+//Scale3x works like Scale2x, turning each pixel into 3x3, with edge pixels also looking at the diagonal neighbors.
+func scale3x(sprite *image.RGBA) sourceMap {
+	bounds := sprite.Bounds()
+	scaled := newSourceMap(bounds.Dx()*3, bounds.Dy()*3)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			points, n := neighborhood(sprite, x, y)
+			a, b, c, d, p, f, g, h, i := n[0], n[1], n[2], n[3], n[4], n[5], n[6], n[7], n[8]
+			const up, left, middle, right, down = 1, 3, 4, 5, 7
+			e := [9]int{middle, middle, middle, middle, middle, middle, middle, middle, middle}
+			if b != h && d != f {
+				if d == b {
+					e[0] = left
+				}
+				if (d == b && p != c) || (b == f && p != a) {
+					e[1] = up
+				}
+				if b == f {
+					e[2] = right
+				}
+				if (d == b && p != g) || (d == h && p != a) {
+					e[3] = left
+				}
+				if (b == f && p != i) || (h == f && p != c) {
+					e[5] = right
+				}
+				if d == h {
+					e[6] = left
+				}
+				if (d == h && p != i) || (h == f && p != g) {
+					e[7] = down
+				}
+				if h == f {
+					e[8] = right
+				}
+			}
+			for j, source := range e {
+				scaled.set(x*3+j%3, y*3+j/3, points[source])
+			}
+		}
+	}
+	return scaled
+}
+
+//Eagle turns each pixel into 2x2, and takes a corner's color from its three neighbors when they all match.
+func eagle(sprite *image.RGBA) sourceMap {
+	bounds := sprite.Bounds()
+	scaled := newSourceMap(bounds.Dx()*2, bounds.Dy()*2)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			points, n := neighborhood(sprite, x, y)
+			e := [4]int{4, 4, 4, 4}
+			//each corner's neighbors: the diagonal, then the two sides touching it
+			corners := [4][3]int{{0, 1, 3}, {2, 1, 5}, {6, 7, 3}, {8, 7, 5}}
+			for j, corner := range corners {
+				if n[corner[0]] == n[corner[1]] && n[corner[1]] == n[corner[2]] {
+					e[j] = corner[0]
+				}
+			}
+			for j, source := range e {
+				scaled.set(x*2+j%2, y*2+j/2, points[source])
+			}
+		}
+	}
+	return scaled
+}
+
+//Turns an offset from a pixel a quarter turn, k times.  The blending scalers work out one corner of a pixel, and
+//turn the neighborhood around to do the others.
+func turn(dx, dy, k int) (int, int) {
+	for ; k > 0; k-- {
+		dx, dy = dy, -dx
+	}
+	return dx, dy
+}
+
+//Splits a color into brightness and two color differences, the way hqx and xBR compare colors.  Alpha rides along
+//as a fourth channel, so transparent pixels stand apart from opaque ones.
+func yuv(c color.RGBA) [4]float64 {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	return [4]float64{0.299*r + 0.587*g + 0.114*b, -0.169*r - 0.331*g + 0.5*b, 0.5*r - 0.419*g - 0.081*b, float64(c.A)}
+}
+
+//Mixes colors by weight.  Our sprites are premultiplied, so mixing the channels straight mixes alpha properly too.
+func mix(colors []color.RGBA, weights []int) color.RGBA {
+	var sums [4]int
+	total := 0
+	for j, c := range colors {
+		sums[0] += int(c.R) * weights[j]
+		sums[1] += int(c.G) * weights[j]
+		sums[2] += int(c.B) * weights[j]
+		sums[3] += int(c.A) * weights[j]
+		total += weights[j]
+	}
+	return color.RGBA{uint8((sums[0] + total/2) / total), uint8((sums[1] + total/2) / total), uint8((sums[2] + total/2) / total), uint8((sums[3] + total/2) / total)}
+}
+
+//hqx counts two colors as different when their brightness, color differences or alpha are far enough apart.
+func hqDiffers(a, b color.RGBA) bool {
+	ya, yb := yuv(a), yuv(b)
+	return math.Abs(ya[0]-yb[0]) > 48 || math.Abs(ya[1]-yb[1]) > 7 || math.Abs(ya[2]-yb[2]) > 6 || math.Abs(ya[3]-yb[3]) > 48
+}
+
+//Our hq2x and hq3x are an approximation in the style of Maxim Stepin's hqx, not a port of it, so they won't match
+//the reference output pixel for pixel.  Like hqx, each neighbor that differs from the pixel (by hqx's brightness
+//and color thresholds) marks a side or corner as an edge, but instead of hqx's lookup table of interpolations, a
+//handful of rules turn the edges around each corner into how much of each neighbor gets blended in, so diagonal
+//edges come out smooth and antialiased rather than stepped.
+func hqx(sprite *image.RGBA, factor int) *image.RGBA {
+	bounds := sprite.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*factor, bounds.Dy()*factor))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			for k := 0; k < 4; k++ {
+				at := func(dx, dy int) color.RGBA {
+					dx, dy = turn(dx, dy, k)
+					p := clampedPoint(sprite, x+dx, y+dy)
+					return sprite.RGBAAt(p.X, p.Y)
+				}
+				//the top left corner, and for hq3x, the top edge, turned into place
+				cx, cy := turn(-1, -1, k)
+				if factor == 2 {
+					scaled.SetRGBA(x*2+(cx+1)/2, y*2+(cy+1)/2, hqCorner(at, false))
+					continue
+				}
+				scaled.SetRGBA(x*3+cx+1, y*3+cy+1, hqCorner(at, true))
+				ex, ey := turn(0, -1, k)
+				scaled.SetRGBA(x*3+ex+1, y*3+ey+1, hqEdge(at))
+			}
+			//hq2x's corners cover the whole pixel, while hq3x keeps the middle as it is
+			if factor == 3 {
+				scaled.SetRGBA(x*3+1, y*3+1, sprite.RGBAAt(x, y))
+			}
+		}
+	}
+	return scaled
+}
+
+//Blends the top left corner of a scaled pixel, from the neighbors at offsets around it.
+func hqCorner(at func(dx, dy int) color.RGBA, hq3x bool) color.RGBA {
+	a, b, c, d, e, g := at(-1, -1), at(0, -1), at(1, -1), at(-1, 0), at(0, 0), at(-1, 1)
+	diffA, diffB, diffC, diffD, diffG := hqDiffers(e, a), hqDiffers(e, b), hqDiffers(e, c), hqDiffers(e, d), hqDiffers(e, g)
+	colors := []color.RGBA{e, a, b, d}
+	var weights []int
+	switch {
+	case !diffB && !diffD:
+		//a smooth area, softened a little toward both sides
+		weights = []int{2, 0, 1, 1}
+	case diffB && !diffD && diffA:
+		weights = []int{3, 0, 0, 1}
+	case diffB && !diffD:
+		weights = []int{2, 1, 0, 1}
+	case !diffB && diffD && diffA:
+		weights = []int{3, 0, 1, 0}
+	case !diffB && diffD:
+		weights = []int{2, 1, 1, 0}
+	case hqDiffers(b, d) && diffA:
+		//an edge on either side, but not one running past this corner
+		weights = []int{1, 0, 0, 0}
+	case hqDiffers(b, d):
+		weights = []int{3, 1, 0, 0}
+	case !diffA:
+		weights = []int{2, 0, 1, 1}
+	case hq3x && !diffC && !diffG:
+		//a diagonal edge runs past this corner, so we smooth it
+		weights = []int{2, 0, 7, 7}
+	case hq3x:
+		weights = []int{2, 0, 1, 1}
+	case !diffC && !diffG:
+		weights = []int{2, 0, 1, 1}
+	case diffC && diffG:
+		//a lone corner, which only gets a touch of rounding
+		weights = []int{14, 0, 1, 1}
+	case diffC:
+		weights = []int{5, 0, 2, 1}
+	default:
+		weights = []int{5, 0, 1, 2}
+	}
+	return mix(colors, weights)
+}
+
+//Blends the top edge of a scaled pixel, for hq3x.  An edge running past either top corner pulls in a little of
+//the neighbor above.
+func hqEdge(at func(dx, dy int) color.RGBA) color.RGBA {
+	a, b, c, d, e, f := at(-1, -1), at(0, -1), at(1, -1), at(-1, 0), at(0, 0), at(1, 0)
+	if !hqDiffers(e, b) {
+		return mix([]color.RGBA{e, b}, []int{3, 1})
+	}
+	left := hqDiffers(e, a) && hqDiffers(e, d) && !hqDiffers(b, d)
+	right := hqDiffers(e, c) && hqDiffers(e, f) && !hqDiffers(b, f)
+	switch {
+	case left && right:
+		return mix([]color.RGBA{e, b}, []int{3, 1})
+	case left || right:
+		return mix([]color.RGBA{e, b}, []int{7, 1})
+	}
+	return e
+}
+
+//xBR measures how different colors are by their brightness, color differences and alpha, with brightness
+//counting most.
+func xbrDistance(a, b color.RGBA) float64 {
+	ya, yb := yuv(a), yuv(b)
+	return 48*math.Abs(ya[0]-yb[0]) + 7*math.Abs(ya[1]-yb[1]) + 6*math.Abs(ya[2]-yb[2]) + 48*math.Abs(ya[3]-yb[3])
+}
+
+//Colors close enough that xBR treats them as the same.
+func xbrClose(a, b color.RGBA) bool {
+	return xbrDistance(a, b) < 155
+}
+
+//Moves a color part of the way to another, by alpha out of 256.
+func blendToward(dst, src color.RGBA, alpha int) color.RGBA {
+	return mix([]color.RGBA{dst, src}, []int{256 - alpha, alpha})
+}
+
+//xBR (scale by rules), by Hyllian, turns each pixel into 2x2.  For each corner it weighs how strongly the colors
+//run along the diagonal through the corner against across it, looking two pixels out, and where an edge runs
+//across, it blends the corner toward the color on the other side.  Shallow and steep edges blend more of the
+//scaled pixel, so lines of any angle come out smooth.
+func xbr(sprite *image.RGBA) *image.RGBA {
+	bounds := sprite.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*2, bounds.Dy()*2))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			p := sprite.RGBAAt(x, y)
+			e := [4]color.RGBA{p, p, p, p}
+			//the bottom right corner, then the others turned into place
+			for k := 0; k < 4; k++ {
+				at := func(dx, dy int) color.RGBA {
+					dx, dy = turn(dx, dy, k)
+					p := clampedPoint(sprite, x+dx, y+dy)
+					return sprite.RGBAAt(p.X, p.Y)
+				}
+				corner := func(dx, dy int) int {
+					dx, dy = turn(dx, dy, k)
+					return (dy+1)/2*2 + (dx+1)/2
+				}
+				xbrCorner(at, e[:], corner(1, 1), corner(-1, 1), corner(1, -1))
+			}
+			for j, c := range e {
+				scaled.SetRGBA(x*2+j%2, y*2+j/2, c)
+			}
+		}
+	}
+	return scaled
+}
+
+//Blends the bottom right corner of a scaled pixel, and the two beside it (left and above) for shallow and steep
+//edges.
+func xbrCorner(at func(dx, dy int) color.RGBA, e []color.RGBA, corner, left, up int) {
+	pe, pf, ph := at(0, 0), at(1, 0), at(0, 1)
+	if pe == pf || pe == ph {
+		return
+	}
+	pb, pc, pd, pg, pi := at(0, -1), at(1, -1), at(-1, 0), at(-1, 1), at(1, 1)
+	f4, i4, h5, i5 := at(2, 0), at(2, 1), at(0, 2), at(1, 2)
+	d := xbrDistance
+	across := d(pe, pc) + d(pe, pg) + d(pi, h5) + d(pi, f4) + 4*d(ph, pf)
+	along := d(ph, pd) + d(ph, i5) + d(pf, i4) + d(pf, pb) + 4*d(pe, pi)
+	px := pf
+	if d(pe, pf) > d(pe, ph) {
+		px = ph
+	}
+	if across < along && (!xbrClose(pf, pb) && !xbrClose(ph, pd) || xbrClose(pe, pi) && !xbrClose(pf, i4) && !xbrClose(ph, i5) || xbrClose(pe, pg) || xbrClose(pe, pc)) {
+		ke, ki := d(pf, pg), d(ph, pc)
+		shallow := 2*ke <= ki && pe != pg && pd != pg
+		steep := ke >= 2*ki && pe != pc && pb != pc
+		switch {
+		case shallow && steep:
+			e[corner] = blendToward(e[corner], px, 224)
+			e[left] = blendToward(e[left], px, 64)
+			e[up] = e[left]
+		case shallow:
+			e[corner] = blendToward(e[corner], px, 192)
+			e[left] = blendToward(e[left], px, 64)
+		case steep:
+			e[corner] = blendToward(e[corner], px, 192)
+			e[up] = blendToward(e[up], px, 64)
+		default:
+			e[corner] = blendToward(e[corner], px, 128)
+		}
+	} else if across <= along {
+		e[corner] = blendToward(e[corner], px, 128)
+	}
+}
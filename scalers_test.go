@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+//Builds a sprite from rows of characters, with # for black pixels and anything else for white.
+func spriteFromRows(rows ...string) *image.RGBA {
+	sprite := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				sprite.SetRGBA(x, y, Black)
+			} else {
+				sprite.SetRGBA(x, y, White)
+			}
+		}
+	}
+	return sprite
+}
+
+func checkRows(t *testing.T, name string, got *image.RGBA, rows ...string) {
+	want := spriteFromRows(rows...)
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("%v: got a %v sprite, want %v", name, got.Bounds(), want.Bounds())
+	}
+	for y := range rows {
+		for x := range rows[y] {
+			if got.RGBAAt(x, y) != want.RGBAAt(x, y) {
+				t.Fatalf("%v: wrong color %v at %v,%v", name, got.RGBAAt(x, y), x, y)
+			}
+		}
+	}
+}
+
+func TestScalers(t *testing.T) {
+	fmt.Printf("TestScalers\n")
+	//a diagonal step gets its corner rounded off
+	step := spriteFromRows(
+		"#.",
+		"##")
+	checkRows(t, "scale2x", scaleSprite(step, "scale2x"),
+		"##..",
+		"###.",
+		"####",
+		"####")
+	checkRows(t, "eagle", scaleSprite(step, "eagle"),
+		"##..",
+		"###.",
+		"####",
+		"####")
+	checkRows(t, "scale3x", scaleSprite(step, "scale3x"),
+		"###...",
+		"####..",
+		"#####.",
+		"######",
+		"######",
+		"######")
+	//a lone pixel has nothing to round off
+	dot := spriteFromRows(
+		"...",
+		".#.",
+		"...")
+	checkRows(t, "scale2x dot", scaleSprite(dot, "scale2x"),
+		"......",
+		"......",
+		"..##..",
+		"..##..",
+		"......",
+		"......")
+
+	for scaler, want := range map[string]int{"nearest": 1, "Scale2x": 2, "scale3x": 3, "scale4x": 4, "eagle": 2, "hq2x": 2, "HQ3x": 3, "xbr": 2} {
+		factor, err := scalerFactor(scaler)
+		if err != nil || factor != want {
+			t.Errorf("%v should scale by %v, got %v", scaler, want, factor)
+		}
+		if got := scaleSprite(dot, scaler).Bounds().Dx(); got != 3*want {
+			t.Errorf("%v made a sprite %v wide, want %v", scaler, got, 3*want)
+		}
+	}
+	if _, err := scalerFactor("hq9x"); err == nil {
+		t.Errorf("Unknown scalers should fail")
+	}
+}
+
+func TestBlendingScalers(t *testing.T) {
+	fmt.Printf("TestBlendingScalers\n")
+	//a shallow slope, which should come out smooth, with colors between black and white along it
+	slope := spriteFromRows(
+		"......",
+		"....##",
+		"..####",
+		"######")
+	for _, scaler := range []string{"hq2x", "hq3x", "xbr"} {
+		if !scalerBlends(scaler) {
+			t.Errorf("%v should count as blending", scaler)
+		}
+		scaled := scaleSprite(slope, scaler)
+		bounds := scaled.Bounds()
+		if scaled.RGBAAt(0, 0) != White || scaled.RGBAAt(0, bounds.Dy()-1) != Black {
+			t.Errorf("%v changed the flat parts of the sprite", scaler)
+		}
+		blended := false
+		for p := 0; p < len(scaled.Pix); p += 4 {
+			if scaled.Pix[p] != 0 && scaled.Pix[p] != 255 {
+				blended = true
+			}
+		}
+		if !blended {
+			t.Errorf("%v didn't blend the slope", scaler)
+		}
+	}
+	if scalerBlends("scale2x") {
+		t.Errorf("scale2x only copies pixels")
+	}
+}
+
+func TestScaleAlong(t *testing.T) {
+	fmt.Printf("TestScaleAlong\n")
+	//every pixel of the sprite is black, but their roles differ, so scaling the roles on their own wouldn't round
+	//off the same corner as the sprite
+	step := spriteFromRows(
+		"#.",
+		"##")
+	roles := image.NewRGBA(step.Bounds())
+	roles.Pix[0], roles.Pix[8], roles.Pix[12] = 1, 2, 3
+	for _, scaler := range []string{"scale2x", "scale3x", "scale4x", "eagle"} {
+		scaled := scaleSprite(step, scaler)
+		scaledRoles := scaleAlong(step, roles, scaler)
+		for p := 0; p < len(scaled.Pix); p += 4 {
+			if black := scaled.Pix[p] == 0; black != (scaledRoles.Pix[p] != 0) {
+				t.Fatalf("%v: the role sheet doesn't match the sprite at pixel %v", scaler, p/4)
+			}
+		}
+	}
+	if got := scaleAlong(step, roles, "scale2x").Pix[(1*4+2)*4]; got != 1 {
+		t.Errorf("The rounded corner should take the role of the pixel it copied, got %v", got)
+	}
+}
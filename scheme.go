@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+	"github.com/muesli/gamut"
+)
+
+//A colorRange holds the lowest and highest value we'll pick for a hue, saturation or lightness.
+type colorRange struct {
+	min, max float64
+}
+
+//Reads a range like "0.2:0.8", or a single value, which fixes the range to that value.  Values have to fall
+//between 0 and limit.
+func parseRange(s string, limit float64) (colorRange, error) {
+	ends := strings.Split(s, ":")
+	if len(ends) > 2 {
+		return colorRange{}, fmt.Errorf("bad range %q, use min:max", s)
+	}
+	var values []float64
+	for _, end := range ends {
+		value, err := strconv.ParseFloat(strings.TrimSpace(end), 64)
+		if err != nil || value < 0 || value > limit {
+			return colorRange{}, fmt.Errorf("bad range %q, values go from 0 to %v", s, limit)
+		}
+		values = append(values, value)
+	}
+	r := colorRange{values[0], values[len(values)-1]}
+	if r.min > r.max {
+		r.min, r.max = r.max, r.min
+	}
+	return r, nil
+}
+
+func (r colorRange) pick(random *rand.Rand) float64 {
+	return r.min + random.Float64()*(r.max-r.min)
+}
+
+//colorSampler hands out colors at random, either from a palette or from our hsl ranges.  It keeps its own
+//random source, so the same seed always gives the same colors, however the bits were drawn.
+type colorSampler struct {
+	random                     *rand.Rand
+	palette                    []color.RGBA
+	hue, saturation, lightness colorRange
+}
+
+func (s colorSampler) pick() color.Color {
+	if len(s.palette) > 0 {
+		return s.palette[s.random.Intn(len(s.palette))]
+	}
+	return colorful.Hsl(s.hue.pick(s.random), s.saturation.pick(s.random), s.lightness.pick(s.random)).Clamped()
+}
+
+//Picks a bit, accent and fill color for a single variant.  With a harmony we only pick the bit color, and leave
+//the others to be worked out from it, so they go together.
+func (s colorSampler) scheme(harmony string) map[Pixel]color.Color {
+	scheme := map[Pixel]color.Color{Bit: s.pick()}
+	if harmony == "" {
+		scheme[Accent] = s.pick()
+		scheme[Fill] = s.pick()
+	}
+	return scheme
+}
+
+//Works out the other colors of a sprite from its bit color, using a bit of color theory.  Complementary takes the
+//opposite hue for accents and a muted base for fill, triadic takes the hues a third of the way around the color
+//wheel, analogous takes the hues just either side of the base, and monochrome sticks to lighter and darker
+//versions of the base.  Outlines are always a deep shade of the base, and backgrounds a pale tint.
+func harmonize(base color.Color, harmony string) (map[Pixel]color.Color, error) {
+	colors := map[Pixel]color.Color{Outline: gamut.Shades(base, 4)[3], Background: gamut.Tints(base, 4)[3]}
+	switch strings.ToLower(harmony) {
+	case "complementary":
+		colors[Accent] = gamut.Complementary(base)
+		colors[Fill] = gamut.Tones(base, 3)[1]
+	case "triadic":
+		triad := gamut.Triadic(base)
+		colors[Accent], colors[Fill] = triad[0], triad[1]
+	case "analogous":
+		neighbors := gamut.Analogous(base)
+		colors[Accent], colors[Fill] = neighbors[0], neighbors[1]
+	case "monochrome":
+		colors[Accent] = gamut.Lighter(base, .3)
+		colors[Fill] = gamut.Darker(base, .2)
+	default:
+		return nil, errors.New("unknown harmony " + harmony + ", use complementary, triadic, analogous or monochrome")
+	}
+	return colors, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+func TestParseRange(t *testing.T) {
+	fmt.Printf("TestParseRange\n")
+	cases := map[string]colorRange{"0.2:0.8": {.2, .8}, ".8:.2": {.2, .8}, "0.5": {.5, .5}, "90:180": {90, 180}}
+	for s, want := range cases {
+		got, err := parseRange(s, 360)
+		if err != nil || got != want {
+			t.Errorf("parseRange(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "a:b", "0:1:2", "-1:1", "0:400"} {
+		if _, err := parseRange(s, 360); err == nil {
+			t.Errorf("parseRange(%q) should fail", s)
+		}
+	}
+}
+
+func TestRandomSchemes(t *testing.T) {
+	fmt.Printf("TestRandomSchemes\n")
+	hsl := func(seed int64) colorSampler {
+		return colorSampler{random: rand.New(rand.NewSource(seed)), hue: colorRange{0, 360}, saturation: colorRange{.5, .5}, lightness: colorRange{.5, .5}}
+	}
+	//the same seed should always give us the same colors
+	first, second := hsl(7), hsl(7)
+	for i := 0; i < 16; i++ {
+		a, b := first.scheme(""), second.scheme("")
+		for _, key := range []Pixel{Bit, Accent, Fill} {
+			if hexColor(a[key]) != hexColor(b[key]) {
+				t.Fatalf("Seeded colors don't match, %v and %v", hexColor(a[key]), hexColor(b[key]))
+			}
+		}
+	}
+	//with a harmony, we only pick the bit color
+	if scheme := hsl(7).scheme("triadic"); len(scheme) != 1 || scheme[Bit] == nil {
+		t.Errorf("Harmonized schemes should only pick a bit color, got %v", scheme)
+	}
+
+	//colors picked from a palette stay on the palette
+	palette := []color.RGBA{{20, 12, 28, 255}, {218, 212, 94, 255}, {89, 125, 206, 255}, {208, 70, 72, 255}}
+	sampler := colorSampler{random: rand.New(rand.NewSource(1)), palette: palette}
+	for i := 0; i < 16; i++ {
+		for _, c := range sampler.scheme("") {
+			if nearestColor(c, palette) != c {
+				t.Errorf("%v is not on the palette", hexColor(c))
+			}
+		}
+	}
+}
+
+func TestHarmony(t *testing.T) {
+	fmt.Printf("TestHarmony\n")
+	base := colorful.Hsv(200, .6, .8)
+	//triadic colors sit a third of the way around the color wheel from the base
+	colors, err := harmonize(base, "triadic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []Pixel{Accent, Fill} {
+		offset := math.Mod(hue(colors[key])-hue(base)+360, 360)
+		if math.Abs(offset-120) > 1 && math.Abs(offset-240) > 1 {
+			t.Errorf("Triadic color is %v degrees away from the base", offset)
+		}
+	}
+	if offset := math.Abs(hue(mustHarmonize(t, base, "complementary")[Accent]) - hue(base)); math.Abs(offset-180) > 1 {
+		t.Errorf("Complementary accent is %v degrees away from the base", offset)
+	}
+	//monochrome keeps the hue, and every harmony has darker outlines and lighter backgrounds than the base
+	for _, harmony := range []string{"complementary", "triadic", "analogous", "monochrome"} {
+		colors := mustHarmonize(t, base, harmony)
+		if len(colors) != 4 {
+			t.Errorf("%v should give 4 colors, got %v", harmony, len(colors))
+		}
+		if lightness(toRGBA(colors[Outline])) >= lightness(toRGBA(base)) || lightness(toRGBA(colors[Background])) <= lightness(toRGBA(base)) {
+			t.Errorf("%v outline and background should be darker and lighter than the base", harmony)
+		}
+	}
+	baseHue, _, _ := base.Hcl()
+	for _, key := range []Pixel{Accent, Fill} {
+		mono, _ := colorful.MakeColor(mustHarmonize(t, base, "monochrome")[key])
+		if monoHue, _, _ := mono.Hcl(); math.Abs(monoHue-baseHue) > 5 {
+			t.Errorf("Monochrome colors should keep the base hue")
+		}
+	}
+	if _, err := harmonize(base, "clashing"); err == nil {
+		t.Errorf("Unknown harmonies should fail")
+	}
+}
+
+func mustHarmonize(t *testing.T, base color.Color, harmony string) map[Pixel]color.Color {
+	colors, err := harmonize(base, harmony)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return colors
+}
+
+func toRGBA(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+func hue(c color.Color) float64 {
+	col, _ := colorful.MakeColor(c)
+	h, _, _ := col.Hsv()
+	return h
+}
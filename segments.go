@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/muesli/gamut"
+)
+
+//Pulls segment color flags, like -color.2=#ff00ff or -fill.leaves #00ff00, out of our arguments before the flag
+//package sees them, since it can't handle flag names it doesn't know ahead of time.  Segments are counted from 0,
+//and parts of a composite template can also go by their name.  We hand back the arguments we didn't use, and
+//the color strings for each segment.
+func segmentColorArgs(args []string) ([]string, map[string]map[Pixel]string, error) {
+	var rest []string
+	colors := make(map[string]map[Pixel]string)
+	for a := 0; a < len(args); a++ {
+		arg := args[a]
+		//everything after a lone -- isn't a flag
+		if arg == "--" {
+			rest = append(rest, args[a:]...)
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		dot := strings.Index(name, ".")
+		if !strings.HasPrefix(arg, "-") || dot == -1 || (strings.Contains(name, "=") && strings.Index(name, "=") < dot) {
+			rest = append(rest, arg)
+			continue
+		}
+		pixel, ok := colorFlags[strings.ToLower(name[:dot])]
+		if !ok {
+			rest = append(rest, arg)
+			continue
+		}
+		segment, value := name[dot+1:], ""
+		if equals := strings.Index(segment, "="); equals != -1 {
+			segment, value = segment[:equals], segment[equals+1:]
+		} else if a+1 < len(args) {
+			a++
+			value = args[a]
+		} else {
+			return nil, nil, fmt.Errorf("flag needs an argument: %v", arg)
+		}
+		if colors[segment] == nil {
+			colors[segment] = make(map[Pixel]string)
+		}
+		colors[segment][pixel] = value
+	}
+	return rest, colors, nil
+}
+
+//The color flags, and the pixels they color.
+var colorFlags = map[string]Pixel{"color": Bit, "accent": Accent, "fill": Fill, "background": Background, "outcolor": Outline, "offcolor": Off}
+
+//Works out the colors each segment overrides.  A segment's colors come from the manifest first, then from our
+//segment color flags, which win.  Segments are delimited segments of a template, or the parts of a composite
+//template, so keys can be a segment's number or a part's name.
+func segmentColors(m manifest, segmentCount int, flags map[string]map[Pixel]string, blendSpace, easing string) ([]map[Pixel][]color.Color, error) {
+	overrides := make([]map[Pixel]string, segmentCount)
+	for s := range overrides {
+		overrides[s] = make(map[Pixel]string)
+		var manifestColors map[string]string
+		if len(m.Parts) > 0 {
+			manifestColors = m.Parts[s].Colors
+		} else if s < len(m.Segments) {
+			manifestColors = m.Segments[s].Colors
+		}
+		for name, value := range manifestColors {
+			pixel, ok := pixelNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown pixel %q in segment %v colors", name, s)
+			}
+			overrides[s][pixel] = value
+		}
+	}
+	for key, values := range flags {
+		s, err := strconv.Atoi(key)
+		if len(m.Parts) > 0 {
+			number := s
+			s = -1
+			for p, pt := range m.Parts {
+				if strings.EqualFold(pt.Name, key) || (err == nil && pt.order == number) {
+					s = p
+				}
+			}
+		} else if err != nil {
+			s = -1
+		}
+		if s < 0 || s >= segmentCount {
+			return nil, fmt.Errorf("no segment %v to color, the template has %v", key, segmentCount)
+		}
+		for pixel, value := range values {
+			overrides[s][pixel] = value
+		}
+	}
+
+	colors := make([]map[Pixel][]color.Color, segmentCount)
+	for s := range overrides {
+		colors[s] = make(map[Pixel][]color.Color)
+		for pixel, value := range overrides[s] {
+			colors[s][pixel] = blendColors(strings.Split(value, ":"), blendSpace, easing)
+		}
+	}
+	return colors, nil
+}
+
+//Turns color strings into colors, one for a single Hex, or a gradient across every variant for more than one.
+func blendColors(hexes []string, blendSpace, easing string) []color.Color {
+	if len(hexes) == 1 {
+		return []color.Color{gamut.Hex(hexes[0])}
+	}
+	var stops []color.Color
+	for _, hex := range hexes {
+		stops = append(stops, gamut.Hex(hex))
+	}
+	return gradient(stops, 256, blendSpace, easing)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSegmentColorArgs(t *testing.T) {
+	fmt.Printf("TestSegmentColorArgs\n")
+	args := []string{"-template=Flower", "-color.2=#ff00ff", "--fill.leaves", "#00ff00", "-color=#ffffff", "-outcolor.0=#000000:#ffffff", "-outname", "a.b"}
+	rest, colors, err := segmentColorArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rest, " ") != "-template=Flower -color=#ffffff -outname a.b" {
+		t.Errorf("Unexpected leftover args %v", rest)
+	}
+	if colors["2"][Bit] != "#ff00ff" || colors["leaves"][Fill] != "#00ff00" || colors["0"][Outline] != "#000000:#ffffff" {
+		t.Errorf("Unexpected segment colors %v", colors)
+	}
+	if _, _, err := segmentColorArgs([]string{"-color.1"}); err == nil {
+		t.Errorf("Segment colors without a value should fail")
+	}
+}
+
+func TestSegmentColors(t *testing.T) {
+	fmt.Printf("TestSegmentColors\n")
+	//manifest colors are used unless a flag says otherwise
+	m := manifest{Segments: []segment{{Colors: map[string]string{"bit": "#ff0000", "fill": "#00ff00"}}}}
+	flags := map[string]map[Pixel]string{"1": {Fill: "#0000ff:#ffffff"}, "0": {Fill: "#ffff00"}}
+	colors, err := segmentColors(m, 2, flags, "lab", "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hexColor(colors[0][Bit][0]) != "#ff0000" || hexColor(colors[0][Fill][0]) != "#ffff00" {
+		t.Errorf("Segment 0 should have a red bit and yellow fill")
+	}
+	if len(colors[1][Fill]) != 256 || colors[1][Bit] != nil {
+		t.Errorf("Segment 1 should only blend its fill")
+	}
+	if _, err := segmentColors(m, 2, map[string]map[Pixel]string{"2": {Bit: "#ffffff"}}, "lab", "linear"); err == nil {
+		t.Errorf("Coloring a missing segment should fail")
+	}
+
+	//parts go by their name, or where they were listed in the manifest
+	m = manifest{Parts: []part{{Name: "stem", order: 1}, {Name: "flower", order: 0}}}
+	colors, err = segmentColors(m, 2, map[string]map[Pixel]string{"stem": {Bit: "#00ff00"}, "0": {Bit: "#ff00ff"}}, "lab", "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hexColor(colors[0][Bit][0]) != "#00ff00" || hexColor(colors[1][Bit][0]) != "#ff00ff" {
+		t.Errorf("Parts were colored by the wrong names")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"strings"
+
+	"github.com/muesli/gamut"
+)
+
+//Turns a light direction like "topleft" into the step we take from a pixel to walk toward the light.
+func lightDirection(name string) (image.Point, error) {
+	directions := map[string]image.Point{
+		"topleft": {-1, -1}, "top": {0, -1}, "topright": {1, -1},
+		"left": {-1, 0}, "right": {1, 0},
+		"bottomleft": {-1, 1}, "bottom": {0, 1}, "bottomright": {1, 1},
+	}
+	light, ok := directions[strings.ToLower(name)]
+	if !ok {
+		return image.Point{}, errors.New("unknown light direction " + name + ", use top, bottom, left, right, or a corner like topleft")
+	}
+	return light, nil
+}
+
+//Shades a sprite as though it was lit from one side.  Much like our outlines, we look at each body pixel's
+//neighbors.  A pixel on the edge of its region facing the light is lightened, while one on the edge facing away
+//is darkened, so each region of bits, accents and fill picks up its own highlight and shadow.  Pixels that face
+//both ways, like one pixel wide lines, are left alone.  Strength is how far we lighten or darken, from 0 to 1.
+func shadeSprite(sprite *image.RGBA, roles []Pixel, light image.Point, strength float64) {
+	width, height := sprite.Bounds().Dx(), sprite.Bounds().Dy()
+	var toward []image.Point
+	if light.X != 0 {
+		toward = append(toward, image.Point{light.X, 0})
+	}
+	if light.Y != 0 {
+		toward = append(toward, image.Point{0, light.Y})
+	}
+	//Anything past the edge of the canvas, or part of another region, counts as the edge of this region.
+	edge := func(x, y int, step image.Point) bool {
+		nx, ny := x+step.X, y+step.Y
+		if nx < 0 || nx >= width || ny < 0 || ny >= height {
+			return true
+		}
+		return roles[nx+ny*width] != roles[x+y*width]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			//transparent pixels have nothing to shade
+			if !solid(roles[x+y*width]) || sprite.RGBAAt(x, y).A == 0 {
+				continue
+			}
+			lit, shadowed := false, false
+			for _, step := range toward {
+				lit = lit || edge(x, y, step)
+				shadowed = shadowed || edge(x, y, image.Point{-step.X, -step.Y})
+			}
+			if lit == shadowed {
+				continue
+			}
+			c := sprite.At(x, y)
+			if lit {
+				sprite.Set(x, y, gamut.Lighter(c, strength))
+			} else {
+				sprite.Set(x, y, gamut.Darker(c, strength))
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestShading(t *testing.T) {
+	fmt.Printf("TestShading\n")
+	//a 3x3 block of fill in the middle of a 5x5 canvas
+	flat := color.RGBA{100, 120, 140, 255}
+	roles := make([]Pixel, 25)
+	sprite := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	for y := 1; y < 4; y++ {
+		for x := 1; x < 4; x++ {
+			roles[x+y*5] = Fill
+			sprite.SetRGBA(x, y, flat)
+		}
+	}
+	light, err := lightDirection("TopLeft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadeSprite(sprite, roles, light, .3)
+
+	brighter := func(a, b color.RGBA) bool { return lightness(a) > lightness(b) }
+	if !brighter(sprite.RGBAAt(1, 1), flat) {
+		t.Errorf("The corner facing the light should be lightened")
+	}
+	if !brighter(flat, sprite.RGBAAt(3, 3)) {
+		t.Errorf("The corner facing away from the light should be darkened")
+	}
+	//the middle isn't on an edge, and the other corners face both ways
+	for _, p := range []image.Point{{2, 2}, {3, 1}, {1, 3}} {
+		if sprite.RGBAAt(p.X, p.Y) != flat {
+			t.Errorf("Pixel %v should be left alone", p)
+		}
+	}
+	if sprite.RGBAAt(0, 0) != (color.RGBA{}) {
+		t.Errorf("Background pixels shouldn't be shaded")
+	}
+	if _, err := lightDirection("inside"); err == nil {
+		t.Errorf("Unknown light directions should fail")
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+//sheetMetadata describes where each variant ended up on the sprite sheet, since after filtering a variant's
+//cell no longer tells us its original index.
+type sheetMetadata struct {
+	Image        string   `json:"image"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	Padding      int      `json:"padding,omitempty"`      //empty pixels around the edge of the sheet
+	Spacing      int      `json:"spacing,omitempty"`      //empty pixels between cells
+	Extrude      int      `json:"extrude,omitempty"`      //edge pixels copied outward around each frame
+	Pages        []string `json:"pages,omitempty"`        //every page of the sheet, when it's split to fit -maxsize
+	RoleImage    string   `json:"roleImage,omitempty"`    //the sheet in role index form, see roleSprite
+	RolePages    []string `json:"rolePages,omitempty"`    //every page of the role sheet, to match
+	PaletteImage string   `json:"paletteImage,omitempty"` //each frame's colors, one row per frame, in frame order
+	Roles        []string `json:"roles,omitempty"`        //the name of each role, by its index
+	Frames       []frame  `json:"frames"`
+}
+
+//A frame is a single variant's place on the sprite sheet.
+type frame struct {
+	Index       int  `json:"index"`
+	X           int  `json:"x"`
+	Y           int  `json:"y"`
+	W           int  `json:"w"`
+	H           int  `json:"h"`
+	Page        int  `json:"page,omitempty"`
+	Trimmed     bool `json:"trimmed,omitempty"`
+	SourceX     int  `json:"sourceX,omitempty"` //where the trimmed frame sat in the untrimmed sprite
+	SourceY     int  `json:"sourceY,omitempty"`
+	SourceW     int  `json:"sourceW,omitempty"` //the size of the untrimmed sprite
+	SourceH     int  `json:"sourceH,omitempty"`
+	Islands     int  `json:"islands,omitempty"`
+	DuplicateOf *int `json:"duplicateOf,omitempty"`
+}
+
+//Writes our sheet metadata out as json.
+func writeMetadata(out *output, path string, metadata sheetMetadata) {
+	out.write(path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(metadata)
+	})
+}
+
+//A sheetLayout places cells on the sprite sheet in a grid.  Padding is the margin around the whole sheet, spacing
+//the gap between cells, and extrude is how far we copy each sprite's edge pixels outward, so engines that filter
+//textures don't pick up their neighbor's colors.  Extruded pixels sit between the cell and its spacing.
+type sheetLayout struct {
+	columns, rows         int
+	cellWidth, cellHeight int
+	padding, spacing      int
+	extrude               int
+	powerOfTwo            bool
+}
+
+//Finds where a cell's sprite goes on the sheet, not counting its extruded edges.
+func (l sheetLayout) cell(n int) image.Rectangle {
+	x := l.padding + (n%l.columns)*(l.cellWidth+2*l.extrude+l.spacing) + l.extrude
+	y := l.padding + (n/l.columns)*(l.cellHeight+2*l.extrude+l.spacing) + l.extrude
+	return image.Rect(x, y, x+l.cellWidth, y+l.cellHeight)
+}
+
+//A packing is where every cell ended up on the sheet, which page it's on, and how big each page is.
+type packing struct {
+	placements []image.Rectangle
+	pages      []int
+	pageSizes  []image.Point
+}
+
+//Lays count cells out on our grid, perPage cells to a page.
+func (l sheetLayout) grid(count, perPage int) packing {
+	var p packing
+	for n := 0; n < count; n++ {
+		page := n / perPage
+		if page == len(p.pageSizes) {
+			onPage := count - n
+			if onPage > perPage {
+				onPage = perPage
+			}
+			pageLayout := l
+			pageLayout.rows = (onPage + l.columns - 1) / l.columns
+			p.pageSizes = append(p.pageSizes, pageLayout.size())
+		}
+		p.placements = append(p.placements, l.cell(n%perPage))
+		p.pages = append(p.pages, page)
+	}
+	return p
+}
+
+//Packs cells of different sizes onto shelves, keeping them in order.  Cells go left to right until the next one
+//won't fit in the width our grid would have had, then we start a new shelf under the tallest cell of the last
+//one.  With a maxSize, a shelf that won't fit starts a new page.  Pages are cut down to fit what's on them.
+func (l sheetLayout) shelves(sizes []image.Point, maxSize int) (packing, error) {
+	var p packing
+	maxSize = l.limit(maxSize)
+	width := 2*l.padding + l.columns*(l.cellWidth+2*l.extrude) + (l.columns-1)*l.spacing
+	if maxSize > 0 && width > maxSize {
+		width = maxSize
+	}
+	x, y, shelfHeight := l.padding, l.padding, 0
+	var extent image.Point
+	for _, size := range sizes {
+		w, h := size.X+2*l.extrude, size.Y+2*l.extrude
+		if maxSize > 0 && (w+2*l.padding > maxSize || h+2*l.padding > maxSize) {
+			return p, fmt.Errorf("a single image doesn't fit in a %v by %v sheet", maxSize, maxSize)
+		}
+		if x > l.padding && x+w+l.padding > width {
+			x, y, shelfHeight = l.padding, y+shelfHeight+l.spacing, 0
+		}
+		if maxSize > 0 && y > l.padding && y+h+l.padding > maxSize {
+			p.pageSizes = append(p.pageSizes, l.round(extent))
+			x, y, shelfHeight, extent = l.padding, l.padding, 0, image.Point{}
+		}
+		p.placements = append(p.placements, image.Rect(x+l.extrude, y+l.extrude, x+l.extrude+size.X, y+l.extrude+size.Y))
+		p.pages = append(p.pages, len(p.pageSizes))
+		if x+w+l.padding > extent.X {
+			extent.X = x + w + l.padding
+		}
+		if y+h+l.padding > extent.Y {
+			extent.Y = y + h + l.padding
+		}
+		if h > shelfHeight {
+			shelfHeight = h
+		}
+		x += w + l.spacing
+	}
+	p.pageSizes = append(p.pageSizes, l.round(extent))
+	return p, nil
+}
+
+//Finds the smallest rectangle holding everything in the sprite that isn't background.  Backgrounds can be any
+//color, so we compare against the variant's background colors rather than looking for transparency.  Sprites
+//that are all background keep a single pixel, so they still have a frame.
+func trimBounds(sprite *image.RGBA, backgrounds []color.Color) image.Rectangle {
+	empty := make(map[color.RGBA]bool)
+	for _, c := range backgrounds {
+		empty[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+	bounds := image.Rectangle{}
+	for y := sprite.Bounds().Min.Y; y < sprite.Bounds().Max.Y; y++ {
+		for x := sprite.Bounds().Min.X; x < sprite.Bounds().Max.X; x++ {
+			if !empty[sprite.RGBAAt(x, y)] {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if bounds.Empty() {
+		return image.Rect(0, 0, 1, 1)
+	}
+	return bounds
+}
+
+//Shrinks the layout to fit in a maxSize by maxSize texture, and tells us how many cells fit on each page.
+func (l *sheetLayout) fit(maxSize int) (int, error) {
+	maxSize = l.limit(maxSize)
+	maxColumns := (maxSize - 2*l.padding + l.spacing) / (l.cellWidth + 2*l.extrude + l.spacing)
+	maxRows := (maxSize - 2*l.padding + l.spacing) / (l.cellHeight + 2*l.extrude + l.spacing)
+	if maxColumns < 1 || maxRows < 1 {
+		return 0, fmt.Errorf("a single image doesn't fit in a %v by %v sheet", maxSize, maxSize)
+	}
+	if l.columns > maxColumns {
+		l.columns = maxColumns
+	}
+	return l.columns * maxRows, nil
+}
+
+//Works out the size of the whole sheet, rounded up to powers of two if asked.
+func (l sheetLayout) size() image.Point {
+	size := image.Point{
+		2*l.padding + l.columns*(l.cellWidth+2*l.extrude) + (l.columns-1)*l.spacing,
+		2*l.padding + l.rows*(l.cellHeight+2*l.extrude) + (l.rows-1)*l.spacing,
+	}
+	return l.round(size)
+}
+
+//Rounds a sheet size up to powers of two, if asked.
+func (l sheetLayout) round(size image.Point) image.Point {
+	if l.powerOfTwo {
+		size = image.Point{nextPowerOfTwo(size.X), nextPowerOfTwo(size.Y)}
+	}
+	return size
+}
+
+//Pages rounded up to powers of two could grow past a maxSize that isn't one, so we pack against the largest
+//power of two that fits instead.
+func (l sheetLayout) limit(maxSize int) int {
+	if l.powerOfTwo && maxSize > 0 && nextPowerOfTwo(maxSize) != maxSize {
+		return nextPowerOfTwo(maxSize) / 2
+	}
+	return maxSize
+}
+
+//Works out how many columns the sheet should have for count cells.  A square aspect picks the columns that make
+//the sheet closest to square, and a row count picks the fewest columns that fit in those rows.  Otherwise we
+//just use the columns we were given.
+func sheetColumns(count, columns, rows int, aspect string, cellWidth, cellHeight int) int {
+	if strings.EqualFold(aspect, "square") {
+		best, bestRatio := 1, math.Inf(1)
+		for c := 1; c <= count; c++ {
+			r := (count + c - 1) / c
+			ratio := float64(c*cellWidth) / float64(r*cellHeight)
+			if ratio < 1 {
+				ratio = 1 / ratio
+			}
+			if ratio < bestRatio {
+				best, bestRatio = c, ratio
+			}
+		}
+		return best
+	}
+	if rows > 0 && count > 0 {
+		return (count + rows - 1) / rows
+	}
+	return columns
+}
+
+func nextPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power *= 2
+	}
+	return power
+}
+
+//Draws a sprite onto the sheet, then copies its edge pixels outward extrude pixels.
+func placeSprite(sheet *image.RGBA, placement image.Rectangle, sprite *image.RGBA, extrude int) {
+	outer := placement.Inset(-extrude)
+	for y := outer.Min.Y; y < outer.Max.Y; y++ {
+		for x := outer.Min.X; x < outer.Max.X; x++ {
+			sx := sprite.Bounds().Min.X + clamp(x-placement.Min.X, 0, placement.Dx()-1)
+			sy := sprite.Bounds().Min.Y + clamp(y-placement.Min.Y, 0, placement.Dy()-1)
+			sheet.SetRGBA(x, y, sprite.RGBAAt(sx, sy))
+		}
+	}
+}
+
+func clamp(n, low, high int) int {
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
+
+//Collects every color used by our sprites into a palette, in the order we come across them.  Transparent always
+//comes first, so empty cells on the sheet have a color, and engines can count on index 0 being see-through.
+//PNG palettes only hold 256 colors, so we report whether everything fit.
+func collectPalette(sprites []*image.RGBA) (color.Palette, bool) {
+	palette := color.Palette{color.RGBA{}}
+	seen := map[color.RGBA]bool{{}: true}
+	for _, sprite := range sprites {
+		for p := 0; p < len(sprite.Pix); p += 4 {
+			c := color.RGBA{sprite.Pix[p], sprite.Pix[p+1], sprite.Pix[p+2], sprite.Pix[p+3]}
+			if c.A == 0 {
+				c = color.RGBA{}
+			}
+			if !seen[c] {
+				seen[c] = true
+				palette = append(palette, c)
+			}
+		}
+	}
+	return palette, len(palette) <= 256
+}
+
+//Copies a sprite onto the palette, which must hold every color of the sprite.  We look colors up exactly, rather
+//than letting image/draw pick the closest, so nothing shifts, and a color the palette is missing is an error rather
+//than quietly becoming the first entry.
+func toPaletted(sprite *image.RGBA, palette color.Palette) (*image.Paletted, error) {
+	indexes := make(map[color.Color]uint8)
+	for j, c := range palette {
+		indexes[c] = uint8(j)
+	}
+	paletted := image.NewPaletted(sprite.Bounds(), palette)
+	for p := 0; p < len(sprite.Pix); p += 4 {
+		c := color.RGBA{sprite.Pix[p], sprite.Pix[p+1], sprite.Pix[p+2], sprite.Pix[p+3]}
+		if c.A == 0 {
+			c = color.RGBA{}
+		}
+		j, ok := indexes[c]
+		if !ok {
+			return nil, fmt.Errorf("the color %v isn't in the palette", c)
+		}
+		paletted.Pix[p/4] = j
+	}
+	return paletted, nil
+}
+
+//The names of our pixel roles, in the order of their values.
+var roleNames = []string{"background", "bit", "accent", "fill", "outline", "delimiter", "off"}
+
+//Draws a variant in role index form, for engines that color sprites themselves.  Instead of colors, the red
+//channel of each pixel holds its role and the green channel holds its segment.  Looking up column
+//segment*PixelsDefined + role, in the variant's row of the palette texture, gives back the pixel's color.
+func roleSprite(v variant) *image.RGBA {
+	sprite := image.NewRGBA(v.sprite.Bounds())
+	for j, role := range v.roles {
+		sprite.Pix[j*4] = uint8(role)
+		sprite.Pix[j*4+1] = uint8(v.segments[j])
+		sprite.Pix[j*4+3] = 255
+	}
+	return sprite
+}
+
+//Builds a palette texture to go with our role sprites, one row per variant, holding every color of every segment.
+func paletteTexture(variants []variant) *image.RGBA {
+	segments := 1
+	for _, v := range variants {
+		if len(v.colors[Bit]) > segments {
+			segments = len(v.colors[Bit])
+		}
+	}
+	texture := image.NewRGBA(image.Rect(0, 0, segments*int(PixelsDefined), len(variants)))
+	for row, v := range variants {
+		for role := range v.colors {
+			for segment, c := range v.colors[role] {
+				texture.Set(segment*int(PixelsDefined)+role, row, c)
+			}
+		}
+	}
+	return texture
+}
+
+//Scales a sprite up by writing each pixel as a scale by scale square.
+func upscaleSprite(sprite *image.RGBA, scale int) *image.RGBA {
+	if scale == 1 {
+		return sprite
+	}
+	bounds := sprite.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	for y := 0; y < bounds.Dy()*scale; y++ {
+		for x := 0; x < bounds.Dx()*scale; x++ {
+			scaled.SetRGBA(x, y, sprite.RGBAAt(x/scale, y/scale))
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSheetColumns(t *testing.T) {
+	fmt.Printf("TestSheetColumns\n")
+	cases := []struct {
+		count, columns, rows int
+		aspect               string
+		width, height        int
+		want                 int
+	}{
+		{256, 16, 0, "", 9, 9, 16},
+		{256, 7, 0, "", 9, 9, 7},
+		{256, 16, 5, "", 9, 9, 52},
+		{90, 16, 0, "square", 9, 9, 9},
+		{256, 16, 0, "Square", 5, 20, 32},
+		{256, 16, 4, "square", 20, 5, 8},
+	}
+	for _, c := range cases {
+		if got := sheetColumns(c.count, c.columns, c.rows, c.aspect, c.width, c.height); got != c.want {
+			t.Errorf("%+v: got %v columns", c, got)
+		}
+	}
+}
+
+func TestShelves(t *testing.T) {
+	fmt.Printf("TestShelves\n")
+	//room for two 4 wide cells on a shelf, plus a pixel of spacing and padding
+	layout := sheetLayout{columns: 2, cellWidth: 4, cellHeight: 4, padding: 1, spacing: 1}
+	sizes := []image.Point{{4, 2}, {3, 4}, {2, 2}, {4, 1}}
+	sheet, err := layout.shelves(sizes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []image.Rectangle{image.Rect(1, 1, 5, 3), image.Rect(6, 1, 9, 5), image.Rect(1, 6, 3, 8), image.Rect(4, 6, 8, 7)}
+	for n := range want {
+		if sheet.placements[n] != want[n] {
+			t.Errorf("Cell %v went to %v, want %v", n, sheet.placements[n], want[n])
+		}
+	}
+	if len(sheet.pageSizes) != 1 || sheet.pageSizes[0] != (image.Point{10, 9}) {
+		t.Errorf("Got page sizes %v, want one page of 10x9", sheet.pageSizes)
+	}
+	//a max size narrows the shelves, and a shelf that doesn't fit starts the next page
+	sheet, err = layout.shelves(sizes, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.pageSizes) != 2 || sheet.pages[0] != 0 || sheet.pages[1] != 1 || sheet.placements[1] != image.Rect(1, 1, 4, 5) || sheet.placements[3] != image.Rect(1, 6, 5, 7) {
+		t.Errorf("The second shelf should start a new page, got %+v", sheet)
+	}
+	if _, err := layout.shelves(sizes, 4); err == nil {
+		t.Errorf("Cells bigger than our max size should fail")
+	}
+}
+
+func TestTrimBounds(t *testing.T) {
+	fmt.Printf("TestTrimBounds\n")
+	sprite := spriteFromRows(
+		"....",
+		".#..",
+		"..#.",
+		"....")
+	if got := trimBounds(sprite, []color.Color{White}); got != image.Rect(1, 1, 3, 3) {
+		t.Errorf("Got %v, want the middle 2x2", got)
+	}
+	if got := trimBounds(sprite, []color.Color{White, Black}); got != image.Rect(0, 0, 1, 1) {
+		t.Errorf("Empty sprites should keep a single pixel, got %v", got)
+	}
+}
+
+func TestToPaletted(t *testing.T) {
+	fmt.Printf("TestToPaletted\n")
+	sprite := spriteFromRows(
+		".#",
+		"#.")
+	paletted, err := toPaletted(sprite, color.Palette{Black, White})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paletted.Pix[0] != 1 || paletted.Pix[1] != 0 {
+		t.Errorf("Got indexes %v, want 1 0 0 1", paletted.Pix)
+	}
+	if _, err := toPaletted(sprite, color.Palette{Black}); err == nil {
+		t.Errorf("Colors missing from the palette should be an error")
+	}
+}
+
+func TestPowerOfTwoMaxSize(t *testing.T) {
+	fmt.Printf("TestPowerOfTwoMaxSize\n")
+	//five 20 pixel cells fit across 100 pixels, but that rounds up to 128, so we should only get the three that fit
+	//in 64
+	layout := sheetLayout{columns: 5, cellWidth: 20, cellHeight: 20, powerOfTwo: true}
+	perPage, err := layout.fit(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perPage != 9 || layout.columns != 3 {
+		t.Errorf("Got %v cells a page in %v columns, want 9 in 3", perPage, layout.columns)
+	}
+	for _, size := range layout.grid(20, perPage).pageSizes {
+		if size.X > 100 || size.Y > 100 {
+			t.Errorf("Got a %v page, bigger than our max size", size)
+		}
+	}
+	layout.columns = 5
+	sizes := []image.Point{{20, 20}, {20, 20}, {20, 20}, {20, 20}, {20, 20}, {20, 20}}
+	sheet, err := layout.shelves(sizes, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range sheet.pageSizes {
+		if size.X > 100 || size.Y > 100 {
+			t.Errorf("Got a %v shelf page, bigger than our max size", size)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+//A rectangle of a single color in an svg.
+type svgRect struct {
+	x, y, w, h int
+	color      color.NRGBA
+}
+
+//Breaks a sprite into rectangles of a single color.  Each row is split into runs of the same color, and a run
+//that lines up exactly with one on the row above (same start, width and color) just makes that rectangle taller.
+//Transparent pixels are left out, so the background stays transparent.
+func svgRects(sprite *image.RGBA) []svgRect {
+	bounds := sprite.Bounds()
+	var rects []svgRect
+	//the rectangles ending on the last row, by where their run started and how wide it was
+	above := make(map[[2]int]int)
+	for y := 0; y < bounds.Dy(); y++ {
+		current := make(map[[2]int]int)
+		for x := 0; x < bounds.Dx(); {
+			c := sprite.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			end := x + 1
+			for end < bounds.Dx() && sprite.RGBAAt(bounds.Min.X+end, bounds.Min.Y+y) == c {
+				end++
+			}
+			if c.A > 0 {
+				run := [2]int{x, end - x}
+				nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+				if r, ok := above[run]; ok && rects[r].color == nc {
+					rects[r].h++
+					current[run] = r
+				} else {
+					current[run] = len(rects)
+					rects = append(rects, svgRect{x: x, y: y, w: end - x, h: 1, color: nc})
+				}
+			}
+			x = end
+		}
+		above = current
+	}
+	return rects
+}
+
+//Writes a sprite as an svg.  Rectangles are grouped by color, and crispEdges keeps browsers from blurring the
+//seams between them.  Scale sets the svg's size, while the viewBox stays one unit per pixel.
+func encodeSVG(w io.Writer, sprite *image.RGBA, scale int) error {
+	bounds := sprite.Bounds()
+	rects := svgRects(sprite)
+	var colors []color.NRGBA
+	byColor := make(map[color.NRGBA][]svgRect)
+	for _, r := range rects {
+		if byColor[r.color] == nil {
+			colors = append(colors, r.color)
+		}
+		byColor[r.color] = append(byColor[r.color], r)
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"0 0 %v %v\" shape-rendering=\"crispEdges\">\n",
+		bounds.Dx()*scale, bounds.Dy()*scale, bounds.Dx(), bounds.Dy())
+	for _, c := range colors {
+		fmt.Fprintf(out, "<g fill=\"#%02x%02x%02x\"", c.R, c.G, c.B)
+		if c.A < 255 {
+			fmt.Fprintf(out, " fill-opacity=\"%.3g\"", float64(c.A)/255)
+		}
+		fmt.Fprint(out, ">\n")
+		for _, r := range byColor[c] {
+			fmt.Fprintf(out, "<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\"/>\n", r.x, r.y, r.w, r.h)
+		}
+		fmt.Fprint(out, "</g>\n")
+	}
+	fmt.Fprint(out, "</svg>\n")
+	return out.Flush()
+}
+
+//Writes an svg file for a sprite.
+func writeSVG(out *output, path string, sprite *image.RGBA, scale int) {
+	out.write(path, func(w io.Writer) error {
+		return encodeSVG(w, sprite, scale)
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestSVG(t *testing.T) {
+	fmt.Printf("TestSVG\n")
+	//a black square on a white row, with a transparent corner
+	sprite := spriteFromRows(
+		"....",
+		".##.",
+		".##.")
+	sprite.SetRGBA(3, 2, color.RGBA{})
+	rects := svgRects(sprite)
+	want := []svgRect{
+		{0, 0, 4, 1, color.NRGBA{255, 255, 255, 255}},
+		{0, 1, 1, 2, color.NRGBA{255, 255, 255, 255}},
+		{1, 1, 2, 2, color.NRGBA{0, 0, 0, 255}},
+		{3, 1, 1, 1, color.NRGBA{255, 255, 255, 255}},
+	}
+	if len(rects) != len(want) {
+		t.Fatalf("Got rectangles %v, want %v", rects, want)
+	}
+	for n := range want {
+		if rects[n] != want[n] {
+			t.Errorf("Rectangle %v is %v, want %v", n, rects[n], want[n])
+		}
+	}
+
+	var out bytes.Buffer
+	if err := encodeSVG(&out, sprite, 3); err != nil {
+		t.Fatal(err)
+	}
+	svg := out.String()
+	for _, part := range []string{`width="12" height="9" viewBox="0 0 4 3"`, `shape-rendering="crispEdges"`, `<g fill="#000000">`, `<rect x="1" y="1" width="2" height="2"/>`} {
+		if !strings.Contains(svg, part) {
+			t.Errorf("The svg is missing %v:\n%v", part, svg)
+		}
+	}
+	if strings.Count(svg, "<g ") != 2 {
+		t.Errorf("Rectangles should be grouped by color:\n%v", svg)
+	}
+	//subimages, like trimmed sprites, start from their own corner
+	if got := svgRects(sprite.SubImage(image.Rect(1, 1, 3, 3)).(*image.RGBA)); len(got) != 1 || got[0].x != 0 || got[0].w != 2 {
+		t.Errorf("Got rectangles %v for a subimage", got)
+	}
+}
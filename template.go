@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/muesli/gamut"
+)
+
+//A template is the parsed form of a template png.  Pixels are read left to right, top to bottom, so index
+//x+y*width holds the pixel at (x,y).
+type template struct {
+	pixels     []Pixel
+	delimiters []int //indexes where we want to change our bit array
+	width      int
+	height     int
+}
+
+//A manifest describes the things about a template that don't fit in a png.  It either points at a single
+//template png, or describes a composite template, which is assembled from separate part templates rather than
+//one hand merged png.  Width and height are optional, and will grow to fit the parts if they're too small.
+type manifest struct {
+	Template string    `json:"template"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Parts    []part    `json:"parts"`
+	Bits     *bitRules `json:"bits"`     //used by the template, and any segment or part without rules of its own
+	Segments []segment `json:"segments"` //settings for each delimited segment, in the order they are read
+	template template
+}
+
+//A segment holds the settings for one delimited segment of a template.
+type segment struct {
+	Bits   *bitRules         `json:"bits"`
+	Colors map[string]string `json:"colors"` //colors for this segment alone, like "bit": "#ff00ff"
+}
+
+//A part is a single template placed on a composite template.  Parts with a higher Z are drawn over parts with
+//a lower Z.  Optional parts only show up in some variants, with Chance deciding how often (defaults to .5).
+type part struct {
+	Name     string            `json:"name"`
+	Template string            `json:"template"`
+	X        int               `json:"x"`
+	Y        int               `json:"y"`
+	Z        int               `json:"z"`
+	Optional bool              `json:"optional"`
+	Chance   float64           `json:"chance"`
+	Bits     *bitRules         `json:"bits"`
+	Colors   map[string]string `json:"colors"`
+	order    int               //where the part was listed in the manifest, before we sort by Z
+	template template
+}
+
+//Opens a template png and translates it into a simple array.
+func readTemplate(path string) template {
+	templateFile, err := os.Open(path)
+	check(err)
+	defer templateFile.Close()
+
+	//Grab our template pixels and the template config
+	templateStream, err := png.Decode(templateFile)
+	check(err)
+	templateFile.Seek(0, 0)
+	templateConfig, err := png.DecodeConfig(templateFile)
+	check(err)
+
+	t := template{width: templateConfig.Width, height: templateConfig.Height}
+	for y := 0; y < templateConfig.Height; y++ {
+		for x := 0; x < templateConfig.Width; x++ {
+			//Convert pixel model to RGBA.
+			aPixel := color.RGBAModel.Convert(templateStream.At(x, y))
+			//We compare the template's pixels to our defined colors, then append them to our pixels
+			switch aPixel {
+			case Red:
+				t.pixels = append(t.pixels, Outline)
+			case Green:
+				t.pixels = append(t.pixels, Accent)
+			case Blue:
+				t.pixels = append(t.pixels, Fill)
+			case Black:
+				t.pixels = append(t.pixels, Bit)
+			case Magenta:
+				t.pixels = append(t.pixels, Background)
+				t.delimiters = append(t.delimiters, x+y*templateConfig.Width)
+			default:
+				t.pixels = append(t.pixels, Background)
+			}
+		}
+	}
+	return t
+}
+
+//Finds a template's file.  A path to a .png or .json file is used as is, otherwise we look for a manifest, then a
+//png, named after the template in each of our template folders, in order.  A name given with its extension only
+//looks for that kind of file.
+func findTemplate(name string) (string, error) {
+	exts := []string{".json", ".png"}
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".png" || ext == ".json" {
+		if _, err := os.Stat(name); err == nil {
+			return filepath.Abs(name)
+		}
+		name, exts = strings.TrimSuffix(name, filepath.Ext(name)), []string{filepath.Ext(name)}
+	}
+	dirs := templateDirs()
+	for _, dir := range dirs {
+		for _, ext := range exts {
+			path := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("couldn't find template %v (%v), looked in %v", name, strings.Join(exts, " or "), strings.Join(dirs, ", "))
+}
+
+//Names our output after a template, using just its file name, without the folders it's in or its extension.
+func templateBaseName(name string) string {
+	base := filepath.Base(name)
+	if ext := strings.ToLower(filepath.Ext(base)); ext == ".png" || ext == ".json" {
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return base
+}
+
+//The folders we look for templates in: Templates where we're run from, then any folders in the BITSPRITE_PATH
+//environment variable (separated like PATH), then BitSprite/Templates in the user's config folder, so an installed
+//BitSprite can find templates from anywhere.
+func templateDirs() []string {
+	var dirs []string
+	if dir, err := filepath.Abs("Templates"); err == nil {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("BITSPRITE_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if config, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(config, "BitSprite", "Templates"))
+	}
+	return dirs
+}
+
+//Opens a template manifest, then reads its template, or each of its part templates, from templateDir.  Parts are
+//returned sorted by Z, so they can be layered in order.
+func readManifest(path string, templateDir string) manifest {
+	manifestFile, err := os.Open(path)
+	check(err)
+	defer manifestFile.Close()
+
+	var m manifest
+	check(json.NewDecoder(manifestFile).Decode(&m))
+	if len(m.Parts) == 0 {
+		m.template = readTemplate(filepath.Join(templateDir, m.Template+".png"))
+		return m
+	}
+	for j := range m.Parts {
+		check(m.Parts[j].checkOffset())
+		m.Parts[j].template = readTemplate(filepath.Join(templateDir, m.Parts[j].Template+".png"))
+		//Each part already gets its own number, so delimiters inside a part are just background.
+		m.Parts[j].template.delimiters = nil
+		if m.Parts[j].Optional && m.Parts[j].Chance == 0 {
+			m.Parts[j].Chance = .5
+		}
+		//grow the canvas to fit our parts
+		if m.Parts[j].X+m.Parts[j].template.width > m.Width {
+			m.Width = m.Parts[j].X + m.Parts[j].template.width
+		}
+		if m.Parts[j].Y+m.Parts[j].template.height > m.Height {
+			m.Height = m.Parts[j].Y + m.Parts[j].template.height
+		}
+	}
+	for j := range m.Parts {
+		m.Parts[j].order = j
+	}
+	sort.SliceStable(m.Parts, func(a, b int) bool { return m.Parts[a].Z < m.Parts[b].Z })
+	//A composite template is layered onto a blank canvas.
+	m.template = template{width: m.Width, height: m.Height}
+	return m
+}
+
+//Parts are placed from the top left corner of the canvas, which only grows right and down, so offsets can't be
+//negative.
+func (pt part) checkOffset() error {
+	if pt.X < 0 || pt.Y < 0 {
+		return fmt.Errorf("part %v has a negative offset (x %v, y %v), parts are placed from the top left corner of the canvas", pt.Template, pt.X, pt.Y)
+	}
+	return nil
+}
+
+//Returns the bit rules for a delimited segment, falling back to the manifest's rules.  Nil means we just
+//shuffle the numbers like always.
+func (m manifest) segmentRules(s int) *bitRules {
+	if s < len(m.Segments) && m.Segments[s].Bits != nil {
+		return m.Segments[s].Bits
+	}
+	return m.Bits
+}
+
+//Returns the bit rules for a part, falling back to the manifest's rules.
+func (m manifest) partRules(p int) *bitRules {
+	if m.Parts[p].Bits != nil {
+		return m.Parts[p].Bits
+	}
+	return m.Bits
+}
+
+//Counts the bits in each delimited segment of a template.
+func (t template) segmentBits() []int {
+	var counts []int
+	for d := range t.delimiters {
+		end := len(t.pixels)
+		if d+1 < len(t.delimiters) {
+			end = t.delimiters[d+1]
+		}
+		counts = append(counts, countBits(t.pixels[t.delimiters[d]:end]))
+	}
+	return counts
+}
+
+//Reads through template pixels and switches the bit pixels on or off.  We take our resolution number, shift it
+//by the bitsRead, finally checking whether it is even or odd.  This way 0 = all inactive, 255 = all active.  When
+//a delimiter is read, we switch to that segment's number from segmentNumbers and start counting bits over.
+//Inactive bits become whichever pixel off tells us to, usually an outline.
+func resolveBits(t template, resolutionNumber int, segmentNumbers []int, off Pixel) []Pixel {
+	var newImage []Pixel
+	bitsRead := 0
+	for j := 0; j < len(t.pixels); j++ {
+		if d := returnIndex(t.delimiters, j); d != -1 {
+			bitsRead = 0
+			resolutionNumber = segmentNumbers[d]
+		}
+		if t.pixels[j] == Bit {
+			if (resolutionNumber>>(bitsRead%8))&1 == 0 {
+				newImage = append(newImage, off)
+			} else {
+				newImage = append(newImage, Bit)
+			}
+			bitsRead++
+		} else {
+			newImage = append(newImage, t.pixels[j])
+		}
+	}
+	return newImage
+}
+
+//Resolves each part of a composite template with its own number, then layers the parts onto a single image.
+//Background pixels of a part are see-through, everything else covers the parts below it.  Alongside the image
+//we return the part (segment) each pixel came from, so we can color each part separately.
+func layerParts(m manifest, partNumbers []int, present []bool, off Pixel) ([]Pixel, []int) {
+	newImage := make([]Pixel, m.Width*m.Height)
+	segments := make([]int, m.Width*m.Height)
+	for p, pt := range m.Parts {
+		if !present[p] {
+			continue
+		}
+		partImage := resolveBits(pt.template, partNumbers[p], nil, off)
+		for j, pixel := range partImage {
+			if pixel == Background {
+				continue
+			}
+			index := (pt.X + j%pt.template.width) + (pt.Y+j/pt.template.width)*m.Width
+			newImage[index] = pixel
+			segments[index] = p
+		}
+	}
+	return newImage, segments
+}
+
+//outlineStyle changes how we draw outlines.  Neighbors can be 4, for outlines that only touch the sides of the
+//body, or 8, to fill in the corners too.  Thickness adds more rings of outline around the first one, and inner
+//outlines separate fill from bits by outlining fill pixels that touch a bit.
+type outlineStyle struct {
+	neighbors int
+	thickness int
+	inner     bool
+}
+
+//checks neighbors of active, colored pixels.  If the neighboring pixel is a background, replace it with an outline
+//pixel.  When we track segments, the new outline belongs to the segment of the pixel it outlines.  For thicker
+//outlines we do the same again, working out from the ring of outline we just added.
+func outlinePixels(newImage []Pixel, segments []int, width, height int, style outlineStyle) {
+	neighbors := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	if style.neighbors == 8 {
+		neighbors = append(neighbors, image.Point{-1, -1}, image.Point{1, -1}, image.Point{-1, 1}, image.Point{1, 1})
+	}
+	//Inner outlines come first, so the fill pixels we swap still get outlined from the bits next to them.
+	if style.inner {
+		var inner []int
+		for j := range newImage {
+			if newImage[j] != Fill {
+				continue
+			}
+			for _, n := range neighbors {
+				x, y := j%width+n.X, j/width+n.Y
+				if x >= 0 && x < width && y >= 0 && y < height && newImage[x+y*width] == Bit {
+					inner = append(inner, j)
+					break
+				}
+			}
+		}
+		for _, j := range inner {
+			newImage[j] = Outline
+		}
+	}
+	ring := make([]bool, len(newImage))
+	for pass := 0; pass < style.thickness || pass == 0; pass++ {
+		added := make([]bool, len(newImage))
+		for j := range newImage {
+			//our first ring is drawn around the body, later rings around the last ring we drew.
+			if (pass == 0 && !solid(newImage[j])) || (pass > 0 && !ring[j]) {
+				continue
+			}
+			for _, n := range neighbors {
+				x, y := j%width+n.X, j/width+n.Y
+				if x < 0 || x >= width || y < 0 || y >= height {
+					continue
+				}
+				next := x + y*width
+				if newImage[next] == Background {
+					newImage[next] = Outline
+					added[next] = true
+					if segments != nil {
+						segments[next] = segments[j]
+					}
+				}
+			}
+		}
+		ring = added
+	}
+}
+
+//Gives each outline pixel a darkened copy of the color it outlines, rather than one flat color.  Outlines further
+//out take the color of the outline pixel next to them, so thick outlines match the body all the way out.  Any
+//outline that doesn't touch the body at all keeps its color, as do transparent outlines.
+func selectiveOutline(sprite *image.RGBA, roles []Pixel, darken float64) {
+	width, height := sprite.Bounds().Dx(), sprite.Bounds().Dy()
+	neighbors := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {1, -1}, {-1, 1}, {1, 1}}
+	colored := make([]bool, len(roles))
+	for j := range roles {
+		colored[j] = solid(roles[j])
+	}
+	for changed := true; changed; {
+		changed = false
+		var picked []int
+		var colors []color.Color
+		for j := range roles {
+			//transparent outlines were asked for, so we leave them be
+			if roles[j] != Outline || colored[j] || sprite.RGBAAt(j%width, j/width).A == 0 {
+				continue
+			}
+			for _, n := range neighbors {
+				x, y := j%width+n.X, j/width+n.Y
+				if x < 0 || x >= width || y < 0 || y >= height || !colored[x+y*width] || sprite.RGBAAt(x, y).A == 0 {
+					continue
+				}
+				c := sprite.At(x, y)
+				if solid(roles[x+y*width]) {
+					c = gamut.Darker(c, darken)
+				}
+				picked = append(picked, j)
+				colors = append(colors, c)
+				break
+			}
+		}
+		//we color in a whole ring at once, so a ring doesn't copy itself.
+		for k, j := range picked {
+			sprite.Set(j%width, j/width, colors[k])
+			colored[j] = true
+			changed = true
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutlineStyles(t *testing.T) {
+	fmt.Printf("TestOutlineStyles\n")
+	//a single bit in the middle of a 5x5 template
+	dot := func() []Pixel {
+		pixels := make([]Pixel, 25)
+		pixels[12] = Bit
+		return pixels
+	}
+	count := func(pixels []Pixel) int {
+		outlines := 0
+		for _, p := range pixels {
+			if p == Outline {
+				outlines++
+			}
+		}
+		return outlines
+	}
+	styles := map[outlineStyle]int{
+		{neighbors: 4, thickness: 1}: 4,
+		{neighbors: 8, thickness: 1}: 8,
+		{neighbors: 4, thickness: 2}: 12,
+		{neighbors: 8, thickness: 2}: 24,
+	}
+	for style, want := range styles {
+		pixels := dot()
+		outlinePixels(pixels, nil, 5, 5, style)
+		if got := count(pixels); got != want {
+			t.Errorf("%+v drew %v outline pixels, want %v", style, got, want)
+		}
+	}
+
+	//inner outlines swap fill touching a bit, but leave other fill alone
+	pixels := dot()
+	pixels[11], pixels[10] = Fill, Fill
+	segments := make([]int, 25)
+	segments[12] = 1
+	outlinePixels(pixels, segments, 5, 5, outlineStyle{neighbors: 4, thickness: 1, inner: true})
+	if pixels[11] != Outline || pixels[10] != Fill {
+		t.Errorf("Inner outlines should only replace fill touching bits, got %v", pixels[10:13])
+	}
+	if segments[7] != 1 {
+		t.Errorf("Outlines should belong to the segment they outline")
+	}
+}
+
+func TestSelectiveOutline(t *testing.T) {
+	fmt.Printf("TestSelectiveOutline\n")
+	//a red bit, ringed by two rings of black outline
+	roles := make([]Pixel, 25)
+	roles[12] = Bit
+	outlinePixels(roles, nil, 5, 5, outlineStyle{neighbors: 8, thickness: 2})
+	sprite := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	red := color.RGBA{200, 40, 40, 255}
+	for j, role := range roles {
+		if role == Bit {
+			sprite.SetRGBA(j%5, j/5, red)
+		} else {
+			sprite.SetRGBA(j%5, j/5, color.RGBA{0, 0, 0, 255})
+		}
+	}
+	selectiveOutline(sprite, roles, .4)
+	inner, outer := sprite.RGBAAt(1, 1), sprite.RGBAAt(0, 0)
+	if inner.R <= inner.G || lightness(inner) >= lightness(red) {
+		t.Errorf("Outlines should be a darker red, got %v", inner)
+	}
+	if outer != inner {
+		t.Errorf("The outer ring should match the ring inside it, got %v and %v", outer, inner)
+	}
+	if sprite.RGBAAt(2, 2) != red {
+		t.Errorf("The body shouldn't change")
+	}
+}
+
+func TestOffBits(t *testing.T) {
+	fmt.Printf("TestOffBits\n")
+	tmpl := template{pixels: []Pixel{Bit, Bit, Fill, Bit}, width: 4, height: 1}
+	for _, off := range []Pixel{Outline, Background, Fill, Off} {
+		got := resolveBits(tmpl, 5, nil, off)
+		want := []Pixel{Bit, off, Fill, Bit}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("Off bits as %v: got %v, want %v", off, got, want)
+				break
+			}
+		}
+	}
+
+	//off bits drawn as background let the parts below show through
+	lower := template{pixels: []Pixel{Fill, Fill}, width: 2, height: 1}
+	upper := template{pixels: []Pixel{Bit, Bit}, width: 2, height: 1}
+	m := manifest{Width: 2, Height: 1, Parts: []part{{template: lower}, {template: upper}}}
+	got, _ := layerParts(m, []int{0, 1}, []bool{true, true}, Background)
+	if got[0] != Bit || got[1] != Fill {
+		t.Errorf("Background off bits should show the part below, got %v", got)
+	}
+}
+
+func TestFindTemplate(t *testing.T) {
+	fmt.Printf("TestFindTemplate\n")
+	dir := t.TempDir()
+	face, err := os.ReadFile("Templates/Face.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Elsewhere.png"), face, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BITSPRITE_PATH", string(filepath.ListSeparator)+dir)
+	defer os.Unsetenv("BITSPRITE_PATH")
+
+	if path, err := findTemplate("Elsewhere"); err != nil || path != filepath.Join(dir, "Elsewhere.png") {
+		t.Errorf("Templates should be found on BITSPRITE_PATH, got %v, %v", path, err)
+	}
+	//manifests win over pngs, and the Templates folder comes first
+	if path, err := findTemplate("FaceBits"); err != nil || filepath.Base(path) != "FaceBits.json" {
+		t.Errorf("Got %v, %v for a manifest", path, err)
+	}
+	if path, err := findTemplate(filepath.Join(dir, "Elsewhere.png")); err != nil || path != filepath.Join(dir, "Elsewhere.png") {
+		t.Errorf("Paths should be used as is, got %v, %v", path, err)
+	}
+	if _, err := findTemplate("Nowhere"); err == nil {
+		t.Errorf("Missing templates should fail")
+	}
+	//an extension that isn't in our folder is looked for in the template folders, only as that kind of file
+	if path, err := findTemplate("Elsewhere.png"); err != nil || path != filepath.Join(dir, "Elsewhere.png") {
+		t.Errorf("Got %v, %v for a template with its extension", path, err)
+	}
+	if path, err := findTemplate("FaceBits.png"); err == nil {
+		t.Errorf("FaceBits only has a manifest, got %v", path)
+	}
+	//only the file name names our output
+	for name, want := range map[string]string{"Face": "Face", "enemies/slime": "slime", "enemies/Slime.JSON": "Slime", filepath.Join(dir, "Elsewhere.png"): "Elsewhere"} {
+		if got := templateBaseName(name); got != want {
+			t.Errorf("%v named our output %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestPartOffsets(t *testing.T) {
+	fmt.Printf("TestPartOffsets\n")
+	if err := (part{Template: "Stem", X: 2, Y: 0}).checkOffset(); err != nil {
+		t.Errorf("Offsets from the corner should be fine, got %v", err)
+	}
+	for _, pt := range []part{{Template: "Stem", X: -1}, {Template: "Stem", Y: -3}} {
+		if err := pt.checkOffset(); err == nil {
+			t.Errorf("Negative offsets %v,%v should fail", pt.X, pt.Y)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+//A variant is a single resolved sprite, unfolded to its full size, but before any upscaling.  We hang on to
+//the pixel roles and segments alongside the colored sprite, so we can look at the shape of a variant without
+//worrying about its colors.
+type variant struct {
+	index    int     //the variant's number before any filtering or sorting, which also names the individual file
+	numbers  []int   //the resolution number of each segment (or just one, when there are no segments)
+	roles    []Pixel //what each pixel of the unfolded sprite is, read left to right, top to bottom
+	segments []int   //which segment's colors each pixel uses
+	colors   [PixelsDefined][]color.Color
+	sprite   *image.RGBA
+	islands  int //extra pieces floating apart from the main body, only counted when we check connectivity
+	hash     uint64
+	original *int //the index of an earlier variant that looks the same, only set when we check for duplicates
+}
+
+//Is the pixel part of the body of the sprite?  Outlines and background don't count.
+func solid(p Pixel) bool {
+	return p == Bit || p == Accent || p == Fill
+}
+
+//Counts the separate pieces of a sprite's body with a flood fill.  Pixels touch their left, right, up and down
+//neighbors, and with 8-connectivity their diagonal neighbors too.
+func countComponents(roles []Pixel, width, height, connectivity int) int {
+	neighbors := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	if connectivity == 8 {
+		neighbors = append(neighbors, image.Point{-1, -1}, image.Point{1, -1}, image.Point{-1, 1}, image.Point{1, 1})
+	}
+	seen := make([]bool, len(roles))
+	components := 0
+	for j := range roles {
+		if seen[j] || !solid(roles[j]) {
+			continue
+		}
+		components++
+		seen[j] = true
+		stack := []int{j}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, n := range neighbors {
+				x, y := current%width+n.X, current/width+n.Y
+				if x < 0 || x >= width || y < 0 || y >= height {
+					continue
+				}
+				next := x + y*width
+				if !seen[next] && solid(roles[next]) {
+					seen[next] = true
+					stack = append(stack, next)
+				}
+			}
+		}
+	}
+	return components
+}
+
+//Checks every variant for islands.  When discarding, we only return the variants that are in one piece,
+//otherwise we keep them all and just report how many have islands.
+func filterIslands(variants []variant, width, height, connectivity int, mode string) []variant {
+	var accepted []variant
+	withIslands := 0
+	for _, v := range variants {
+		components := countComponents(v.roles, width, height, connectivity)
+		if components > 1 {
+			v.islands = components - 1
+			withIslands++
+			if !strings.EqualFold(mode, "report") {
+				continue
+			}
+		}
+		accepted = append(accepted, v)
+	}
+	if strings.EqualFold(mode, "report") {
+		fmt.Fprintf(messages, "%v of %v variants have islands\n", withIslands, len(variants))
+	} else {
+		fmt.Fprintf(messages, "Discarded %v of %v variants with islands\n", withIslands, len(variants))
+	}
+	return accepted
+}
+
+//Hashes the pixels of a sprite, so we can spot duplicates without comparing every pair of sprites.
+func hashSprite(sprite *image.RGBA) uint64 {
+	h := fnv.New64a()
+	h.Write(sprite.Pix)
+	return h.Sum64()
+}
+
+//Returns a copy of the sprite, flipped left to right, or top to bottom.
+func flipSprite(sprite *image.RGBA, horizontal bool) *image.RGBA {
+	bounds := sprite.Bounds()
+	flipped := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if horizontal {
+				flipped.SetRGBA(bounds.Dx()-x-1, y, sprite.RGBAAt(x, y))
+			} else {
+				flipped.SetRGBA(x, bounds.Dy()-y-1, sprite.RGBAAt(x, y))
+			}
+		}
+	}
+	return flipped
+}
+
+//Finds variants that render exactly like an earlier variant, and with mirrors, variants that are a flipped
+//copy of an earlier one.  When removing, only the first of each look is returned, otherwise we keep them all
+//and note which variant each duplicate copies.  Either way, we report how many unique sprites we found.
+func findDuplicates(variants []variant, mode string, mirrors bool) []variant {
+	seen := make(map[uint64][]variant)
+	var kept []variant
+	duplicates := 0
+	mirrored := 0
+	for _, v := range variants {
+		v.hash = hashSprite(v.sprite)
+		looks := []*image.RGBA{v.sprite}
+		if mirrors {
+			looks = append(looks, flipSprite(v.sprite, true), flipSprite(v.sprite, false))
+		}
+	search:
+		for l, look := range looks {
+			for _, earlier := range seen[hashSprite(look)] {
+				if bytes.Equal(earlier.sprite.Pix, look.Pix) {
+					original := earlier.index
+					v.original = &original
+					duplicates++
+					if l > 0 {
+						mirrored++
+					}
+					break search
+				}
+			}
+		}
+		if v.original == nil {
+			seen[v.hash] = append(seen[v.hash], v)
+		} else if strings.EqualFold(mode, "remove") {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	fmt.Fprintf(messages, "%v unique sprites out of %v variants", len(variants)-duplicates, len(variants))
+	if mirrors {
+		fmt.Fprintf(messages, ", %v of the duplicates are mirror images", mirrored)
+	}
+	fmt.Fprint(messages, "\n")
+	return kept
+}
+
+//Sorts the variants for placement on the sheet.  Sheets are normally laid out by index, but it can be easier to
+//browse them by the number of active bits (popcount), how much of the canvas they cover (density), their most
+//common color (color), gray code, so each neighbor only differs by one bit (gray), or by walking from each
+//sprite to the one that looks the most like it (similarity).
+func orderVariants(variants []variant, order string) ([]variant, error) {
+	switch strings.ToLower(order) {
+	case "", "index":
+	case "popcount":
+		sort.SliceStable(variants, func(a, b int) bool { return popcount(variants[a]) < popcount(variants[b]) })
+	case "density":
+		sort.SliceStable(variants, func(a, b int) bool { return density(variants[a]) < density(variants[b]) })
+	case "color":
+		hues := make(map[int][2]float64)
+		for _, v := range variants {
+			h, _, l := dominantColor(v).Hsl()
+			hues[v.index] = [2]float64{h, l}
+		}
+		sort.SliceStable(variants, func(a, b int) bool {
+			ha, hb := hues[variants[a].index], hues[variants[b].index]
+			if ha[0] != hb[0] {
+				return ha[0] < hb[0]
+			}
+			return ha[1] < hb[1]
+		})
+	case "gray":
+		sort.SliceStable(variants, func(a, b int) bool {
+			ka, kb := grayKey(variants[a]), grayKey(variants[b])
+			for s := range ka {
+				if ka[s] != kb[s] {
+					return ka[s] < kb[s]
+				}
+			}
+			return false
+		})
+	case "similarity":
+		variants = similarityWalk(variants)
+	default:
+		return nil, fmt.Errorf("unknown order %v, use index, popcount, density, color, gray or similarity", order)
+	}
+	return variants, nil
+}
+
+//Counts the active bits across all of a variant's segments.
+func popcount(v variant) int {
+	count := 0
+	for _, n := range v.numbers {
+		count += bits.OnesCount8(uint8(n))
+	}
+	return count
+}
+
+//Counts the pixels of a variant that aren't background.
+func density(v variant) int {
+	count := 0
+	for _, role := range v.roles {
+		if role != Background {
+			count++
+		}
+	}
+	return count
+}
+
+//Finds the most common color of a variant's body, ignoring outlines and background.
+func dominantColor(v variant) colorful.Color {
+	counts := make(map[color.RGBA]int)
+	best := color.RGBA{}
+	for j, role := range v.roles {
+		if !solid(role) {
+			continue
+		}
+		c := v.sprite.RGBAAt(j%v.sprite.Bounds().Dx(), j/v.sprite.Bounds().Dx())
+		counts[c]++
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+	dominant, _ := colorful.MakeColor(best)
+	return dominant
+}
+
+//Turns a gray code back into the count it represents, so sorting by it puts numbers in gray code order.
+func fromGray(n int) int {
+	for shift := n >> 1; shift != 0; shift >>= 1 {
+		n ^= shift
+	}
+	return n
+}
+
+//Reads all of a variant's numbers as one long gray code, first segment first, so every segment is in gray code
+//order, not just the first.  Each bit decodes to the parity of every gray bit up to it, so a segment's bits are
+//flipped when the segments before it have an odd number of bits on.
+func grayKey(v variant) []int {
+	key := make([]int, len(v.numbers))
+	flip := 0
+	for s, n := range v.numbers {
+		key[s] = fromGray(n&0xff) ^ flip
+		if bits.OnesCount8(uint8(n))%2 == 1 {
+			flip ^= 0xff
+		}
+	}
+	return key
+}
+
+//Starting from the first variant, we keep stepping to the closest looking variant we haven't placed yet.
+//Closeness is the distance between each pair of pixels in Lab space, which is kinder to our eyes than RGB.
+func similarityWalk(variants []variant) []variant {
+	if len(variants) == 0 {
+		return variants
+	}
+	labs := make([][][4]float64, len(variants))
+	for j, v := range variants {
+		for p := 0; p < len(v.sprite.Pix); p += 4 {
+			c := colorful.Color{R: float64(v.sprite.Pix[p]) / 255, G: float64(v.sprite.Pix[p+1]) / 255, B: float64(v.sprite.Pix[p+2]) / 255}
+			l, a, b := c.Lab()
+			labs[j] = append(labs[j], [4]float64{l, a, b, float64(v.sprite.Pix[p+3]) / 255})
+		}
+	}
+	distance := func(a, b int) float64 {
+		total := 0.0
+		for p := range labs[a] {
+			pa, pb := labs[a][p], labs[b][p]
+			//transparent pixels all look the same, whatever color they hold.
+			if pa[3] == 0 && pb[3] == 0 {
+				continue
+			}
+			total += math.Sqrt((pa[0]-pb[0])*(pa[0]-pb[0])+(pa[1]-pb[1])*(pa[1]-pb[1])+(pa[2]-pb[2])*(pa[2]-pb[2])) + math.Abs(pa[3]-pb[3])*100
+		}
+		return total
+	}
+
+	placed := make([]bool, len(variants))
+	walk := []int{0}
+	placed[0] = true
+	for len(walk) < len(variants) {
+		current := walk[len(walk)-1]
+		next := -1
+		nextDistance := math.Inf(1)
+		for j := range variants {
+			if placed[j] {
+				continue
+			}
+			if d := distance(current, j); d < nextDistance {
+				next = j
+				nextDistance = d
+			}
+		}
+		placed[next] = true
+		walk = append(walk, next)
+	}
+	ordered := make([]variant, len(variants))
+	for j, w := range walk {
+		ordered[j] = variants[w]
+	}
+	return ordered
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+//Lossless webp (VP8L) stores pixels as prefix (Huffman) coded symbols, along with backward references that copy
+//earlier pixels, and a cache of recently used colors.  We don't bother with the format's transforms, since
+//sprites are mostly flat colors that references and the cache handle well.
+
+//Bits in a webp are packed from the lowest bit of each byte up.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	count uint
+}
+
+func (b *bitWriter) write(value uint32, n uint) {
+	b.bits |= uint64(value) << b.count
+	b.count += n
+	for b.count >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.count -= 8
+	}
+}
+
+func (b *bitWriter) flush() []byte {
+	if b.count > 0 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits, b.count = 0, 0
+	}
+	return b.out
+}
+
+//A prefix code, with the length and bits of each symbol's code.  Codes are stored reversed, since they're read a
+//bit at a time from the low end.
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (p prefixCode) put(b *bitWriter, symbol int) {
+	b.write(p.codes[symbol], uint(p.lengths[symbol]))
+}
+
+//Works out code lengths for a histogram, no longer than limit.  If the codes get too long, we flatten the
+//histogram and try again.  We always give at least two symbols a code, since a lone symbol can't make a
+//complete code.
+func codeLengths(histogram []int, limit int) []int {
+	counts := append([]int(nil), histogram...)
+	used := 0
+	for _, c := range counts {
+		if c > 0 {
+			used++
+		}
+	}
+	for s := 0; used < 2; s++ {
+		if counts[s] == 0 {
+			counts[s] = 1
+			used++
+		}
+	}
+	for {
+		lengths := huffmanLengths(counts)
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= limit {
+			return lengths
+		}
+		for s, c := range counts {
+			if c > 1 {
+				counts[s] = c / 2
+			}
+		}
+	}
+}
+
+//Builds a Huffman tree by repeatedly joining the two lightest nodes, then reads off the depth of each symbol.
+func huffmanLengths(counts []int) []int {
+	type node struct{ weight, left, right, symbol int }
+	var nodes []node
+	var queue []int
+	for s, c := range counts {
+		if c > 0 {
+			nodes = append(nodes, node{c, -1, -1, s})
+			queue = append(queue, len(nodes)-1)
+		}
+	}
+	for len(queue) > 1 {
+		sort.SliceStable(queue, func(i, j int) bool { return nodes[queue[i]].weight < nodes[queue[j]].weight })
+		a, b := queue[0], queue[1]
+		nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, a, b, -1})
+		queue = append(queue[2:], len(nodes)-1)
+	}
+	lengths := make([]int, len(counts))
+	var walk func(n, depth int)
+	walk = func(n, depth int) {
+		if nodes[n].symbol >= 0 {
+			lengths[nodes[n].symbol] = depth
+			return
+		}
+		walk(nodes[n].left, depth+1)
+		walk(nodes[n].right, depth+1)
+	}
+	walk(queue[0], 0)
+	return lengths
+}
+
+//Hands out canonical codes: shorter codes first, then by symbol.
+func canonicalCode(lengths []int) prefixCode {
+	code := prefixCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	next := uint32(0)
+	for length := 1; length <= 15; length++ {
+		for s, l := range lengths {
+			if l != length {
+				continue
+			}
+			for bit := 0; bit < length; bit++ {
+				code.codes[s] |= (next >> uint(length-1-bit) & 1) << uint(bit)
+			}
+			next++
+		}
+		next <<= 1
+	}
+	return code
+}
+
+//The order code length code lengths are written in.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+//Writes a prefix code for a histogram, and hands it back for writing symbols.  One or two small symbols get the
+//format's simple code, anything else has its code lengths written out, themselves run length and prefix coded.
+func writePrefixCode(b *bitWriter, histogram []int) prefixCode {
+	var used []int
+	for s, c := range histogram {
+		if c > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		b.write(1, 1)
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(uint32(used[0]), 1)
+		} else {
+			b.write(1, 1)
+			b.write(uint32(used[0]), 8)
+		}
+		lengths := make([]int, len(histogram))
+		if len(used) == 2 {
+			b.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return canonicalCode(lengths)
+	}
+
+	lengths := codeLengths(histogram, 15)
+	//run length code the lengths: 16 repeats the last length 3-6 times, 17 and 18 repeat 0 3-10 or 11-138 times
+	type token struct{ symbol, extra, extraBits int }
+	var tokens []token
+	for s := 0; s < len(lengths); {
+		run := 1
+		for s+run < len(lengths) && lengths[s+run] == lengths[s] {
+			run++
+		}
+		s += run
+		if lengths[s-run] == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, token{18, n - 11, 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, run - 3, 3})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, token{lengths[s-run], 0, 0})
+			run--
+			for run >= 3 {
+				n := run
+				if n > 6 {
+					n = 6
+				}
+				tokens = append(tokens, token{16, n - 3, 2})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{lengths[s-run], 0, 0})
+		}
+	}
+	tokenHistogram := make([]int, 19)
+	for _, t := range tokens {
+		tokenHistogram[t.symbol]++
+	}
+	lengthCode := canonicalCode(codeLengths(tokenHistogram, 7))
+	count := 19
+	for count > 4 && lengthCode.lengths[codeLengthOrder[count-1]] == 0 {
+		count--
+	}
+	b.write(0, 1)
+	b.write(uint32(count-4), 4)
+	for _, s := range codeLengthOrder[:count] {
+		b.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	//every symbol gets a length, rather than stopping early
+	b.write(0, 1)
+	for _, t := range tokens {
+		lengthCode.put(b, t.symbol)
+		b.write(uint32(t.extra), uint(t.extraBits))
+	}
+	return canonicalCode(lengths)
+}
+
+//Lengths and distances are written as a prefix symbol, then extra bits for the rest of the value.
+func prefixValue(value int) (symbol, extra, extraBits int) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+	high := 0
+	for value>>uint(high+1) > 0 {
+		high++
+	}
+	second := value >> uint(high-1) & 1
+	return 2*high + second, value & (1<<uint(high-1) - 1), high - 1
+}
+
+const webpCacheBits = 8
+
+//How far back copies can reach, the longest distance a distance code can hold.
+const webpWindow = 1<<20 - 120
+
+//A pixel, or a copy of length earlier pixels from distance pixels back.
+type webpSymbol struct {
+	argb             uint32
+	cached           int
+	length, distance int
+}
+
+//Writes a lossless webp.
+func encodeWebP(w io.Writer, img *image.RGBA, palette color.Palette) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > 16384 || height > 16384 {
+		return errors.New("webp images can't be over 16384 pixels wide or tall")
+	}
+	var pixels []uint32
+	alpha := false
+	eachPixel(img, func(c color.NRGBA) {
+		pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		alpha = alpha || c.A < 255
+	})
+
+	//Look for copies of the pixel to the left, the one above, or the last few places the next three pixels showed
+	//up, and take whichever runs longest.  Pixels that aren't copied come from the color cache if they're in it.
+	var symbols []webpSymbol
+	lastSeen := make(map[[3]uint32]int)
+	earlier := make([]int, len(pixels))
+	see := func(p int) {
+		if p+2 < len(pixels) {
+			key := [3]uint32{pixels[p], pixels[p+1], pixels[p+2]}
+			earlier[p] = -1
+			if q, ok := lastSeen[key]; ok {
+				earlier[p] = q
+			}
+			lastSeen[key] = p
+		}
+	}
+	//we only trust cache entries we've filled, since readers needn't start with an empty cache
+	var cache [1 << webpCacheBits]uint32
+	var filled [1 << webpCacheBits]bool
+	cacheIndex := func(argb uint32) int { return int((argb * 0x1e35a7bd) >> (32 - webpCacheBits)) }
+	remember := func(argb uint32) {
+		cache[cacheIndex(argb)], filled[cacheIndex(argb)] = argb, true
+	}
+	for p := 0; p < len(pixels); {
+		length, distance := 0, 0
+		candidates := []int{1, width}
+		if p+2 < len(pixels) {
+			q, ok := lastSeen[[3]uint32{pixels[p], pixels[p+1], pixels[p+2]}]
+			for tries := 0; ok && q >= 0 && tries < 16 && p-q <= webpWindow; tries++ {
+				candidates = append(candidates, p-q)
+				q = earlier[q]
+			}
+		}
+		for _, d := range candidates {
+			if d > p {
+				continue
+			}
+			n := 0
+			for p+n < len(pixels) && n < 4096 && pixels[p+n] == pixels[p+n-d] {
+				n++
+			}
+			if n > length {
+				length, distance = n, d
+			}
+		}
+		if length >= 3 {
+			symbols = append(symbols, webpSymbol{length: length, distance: distance})
+			for _, argb := range pixels[p : p+length] {
+				remember(argb)
+			}
+			for end := p + length; p < end; p++ {
+				see(p)
+			}
+			continue
+		}
+		s := webpSymbol{argb: pixels[p], cached: -1}
+		if i := cacheIndex(pixels[p]); filled[i] && cache[i] == pixels[p] {
+			s.cached = i
+		}
+		remember(pixels[p])
+		symbols = append(symbols, s)
+		see(p)
+		p++
+	}
+
+	//distance codes 1 and 2 are short for the pixel above and the pixel to the left, and others are offset by 120
+	distanceCode := func(distance int) int {
+		if distance == 1 {
+			return 2
+		} else if distance == width {
+			return 1
+		}
+		return distance + 120
+	}
+	green, red, blue, alphas, distances := make([]int, 256+24+1<<webpCacheBits), make([]int, 256), make([]int, 256), make([]int, 256), make([]int, 40)
+	for _, s := range symbols {
+		switch {
+		case s.length > 0:
+			lengthSymbol, _, _ := prefixValue(s.length)
+			distanceSymbol, _, _ := prefixValue(distanceCode(s.distance))
+			green[256+lengthSymbol]++
+			distances[distanceSymbol]++
+		case s.cached >= 0:
+			green[256+24+s.cached]++
+		default:
+			green[s.argb>>8&0xff]++
+			red[s.argb>>16&0xff]++
+			blue[s.argb&0xff]++
+			alphas[s.argb>>24]++
+		}
+	}
+
+	var b bitWriter
+	b.write(0x2f, 8)
+	b.write(uint32(width-1), 14)
+	b.write(uint32(height-1), 14)
+	if alpha {
+		b.write(1, 1)
+	} else {
+		b.write(0, 1)
+	}
+	b.write(0, 3) //version
+	b.write(0, 1) //no transforms
+	b.write(1, 1) //a color cache
+	b.write(webpCacheBits, 4)
+	b.write(0, 1) //one set of prefix codes for the whole image
+	greenCode := writePrefixCode(&b, green)
+	redCode := writePrefixCode(&b, red)
+	blueCode := writePrefixCode(&b, blue)
+	alphaCode := writePrefixCode(&b, alphas)
+	distanceCodes := writePrefixCode(&b, distances)
+	for _, s := range symbols {
+		switch {
+		case s.length > 0:
+			symbol, extra, extraBits := prefixValue(s.length)
+			greenCode.put(&b, 256+symbol)
+			b.write(uint32(extra), uint(extraBits))
+			symbol, extra, extraBits = prefixValue(distanceCode(s.distance))
+			distanceCodes.put(&b, symbol)
+			b.write(uint32(extra), uint(extraBits))
+		case s.cached >= 0:
+			greenCode.put(&b, 256+24+s.cached)
+		default:
+			greenCode.put(&b, int(s.argb>>8&0xff))
+			redCode.put(&b, int(s.argb>>16&0xff))
+			blueCode.put(&b, int(s.argb&0xff))
+			alphaCode.put(&b, int(s.argb>>24))
+		}
+	}
+	data := b.flush()
+
+	//wrap it up in a RIFF container, padded to an even length
+	chunk := len(data) + len(data)%2
+	out := make([]byte, 20, 20+chunk)
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(12+chunk))
+	copy(out[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(out[16:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	_, err := w.Write(out)
+	return err
+}